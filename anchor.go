@@ -0,0 +1,177 @@
+package epub
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TOCTarget 描述目录项在章节中的起点 / TOCTarget is the location a table of contents entry points to.
+type TOCTarget struct {
+	Chapter      *Chapter
+	ChapterIndex int
+	// Block and Paragraph index Chapter.Blocks and Chapter.Paragraphs at the
+	// point where the anchor starts. Both are 0 for entries without fragment.
+	Block     int
+	Paragraph int
+	// Node is the element carrying the fragment id, nil when the entry has no
+	// fragment or the document does not contain the id.
+	Node *HtmlNode
+}
+
+// ChapterForTOC returns the chapter an entry points to and, for entries with
+// a fragment, the block, paragraph and node where the anchor starts. Unknown
+// fragments resolve to the start of the chapter with a nil Node.
+func (b *Book) ChapterForTOC(entry TOC) (TOCTarget, error) {
+	docPath, fragment := entry.target()
+	if docPath == "" {
+		return TOCTarget{}, fmt.Errorf("%w: toc entry %q has no document target", ErrChapterNotFound, entry.Title)
+	}
+	index := b.chapterIndexByPath(docPath)
+	if index < 0 {
+		return TOCTarget{}, fmt.Errorf("%w: %s", ErrChapterNotFound, docPath)
+	}
+	chapter, err := b.ChapterByIndex(index)
+	if err != nil {
+		return TOCTarget{}, err
+	}
+	target := TOCTarget{Chapter: chapter, ChapterIndex: index}
+	if fragment == "" {
+		return target, nil
+	}
+	root, err := b.parseDocument(chapter.Path)
+	if err != nil {
+		return TOCTarget{}, err
+	}
+	loc, ok := newAnchorIndex(root, chapter.Path).locate(fragment)
+	if !ok {
+		return target, nil
+	}
+	target.Block = min(loc.block, max(len(chapter.Blocks)-1, 0))
+	target.Paragraph = min(loc.paragraph, max(len(chapter.Paragraphs)-1, 0))
+	target.Node = loc.node
+	return target, nil
+}
+
+// chapterIndexByPath returns the reading order index of the chapter stored at
+// docPath, or -1.
+func (b *Book) chapterIndexByPath(docPath string) int {
+	if b == nil {
+		return -1
+	}
+	if b.lazy {
+		return slices.IndexFunc(b.spine, func(ref chapterRef) bool { return cleanPath(ref.Href) == docPath })
+	}
+	return slices.IndexFunc(b.Chapters, func(c Chapter) bool { return cleanPath(c.Path) == docPath })
+}
+
+// parseDocument reads and parses the (X)HTML document at name.
+func (b *Book) parseDocument(name string) (root *HtmlNode, err error) {
+	rc, err := b.OpenResource(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, rc.Close())
+	}()
+	return parseHTML(rc, b.limits.MaxDepth)
+}
+
+// anchorLocation is the position of an anchor in the block projection of a
+// document.
+type anchorLocation struct {
+	node      *HtmlNode
+	block     int
+	paragraph int
+}
+
+// anchorIndex locates the anchors of a parsed document in its block
+// projection. Nodes are numbered in document order; an anchor starts in the
+// first top-level block whose source ends after the anchor element, so
+// anchors in elements without content resolve to the following block.
+type anchorIndex struct {
+	anchors map[string]*HtmlNode // first element with each id or <a name>
+	pos     map[*HtmlNode]int    // document order of the nodes of the body
+	ends    []int                // position following the source of each block
+	paras   []int                // paragraph index of each block
+}
+
+// newAnchorIndex builds the block projection of root, a document stored at
+// docPath, and indexes its anchors.
+func newAnchorIndex(root *HtmlNode, docPath string) *anchorIndex {
+	x := &anchorIndex{anchors: make(map[string]*HtmlNode), pos: make(map[*HtmlNode]int)}
+	x.addAnchors(root)
+	body := root.FindNode("body")
+	if body == nil {
+		body = root
+	}
+	ends := make(map[*HtmlNode]int)
+	var number func(*HtmlNode)
+	number = func(n *HtmlNode) {
+		x.pos[n] = len(x.pos)
+		for _, c := range n.Children {
+			number(c)
+		}
+		ends[n] = len(x.pos)
+	}
+	number(body)
+
+	builder := &blockBuilder{base: pathDir(docPath)}
+	blocks, sources := builder.sourcedBlocks(body)
+	paragraph := 0
+	for i, block := range blocks {
+		x.ends = append(x.ends, ends[sources[i].last])
+		x.paras = append(x.paras, paragraph)
+		if block.PlainText() != "" {
+			paragraph++
+		}
+	}
+	x.paras = append(x.paras, paragraph)
+	return x
+}
+
+func (x *anchorIndex) addAnchors(n *HtmlNode) {
+	if n.Type == ElementNode {
+		if id := n.Attrs["id"]; id != "" && x.anchors[id] == nil {
+			x.anchors[id] = n
+		}
+		if name := n.Attrs["name"]; n.Name == "a" && name != "" && x.anchors[name] == nil {
+			x.anchors[name] = n
+		}
+	}
+	for _, c := range n.Children {
+		x.addAnchors(c)
+	}
+}
+
+// ids lists the anchors inside the body in document order.
+func (x *anchorIndex) ids() []string {
+	var ids []string
+	for id, node := range x.anchors {
+		if _, ok := x.pos[node]; ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if d := x.pos[x.anchors[a]] - x.pos[x.anchors[b]]; d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+	return ids
+}
+
+// locate finds the element with the given id (or, for legacy content, the <a>
+// with that name) and reports the top-level block and paragraph it starts in.
+// Anchors after the last block resolve to one past it; anchors outside the
+// body are not found.
+func (x *anchorIndex) locate(id string) (anchorLocation, bool) {
+	node := x.anchors[id]
+	pos, ok := x.pos[node]
+	if !ok {
+		return anchorLocation{}, false
+	}
+	block, _ := slices.BinarySearch(x.ends, pos+1)
+	return anchorLocation{node: node, block: block, paragraph: x.paras[block]}, true
+}
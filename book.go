@@ -3,7 +3,10 @@ package epub
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"strings"
+	"sync"
 )
 
 type Book struct {
@@ -11,6 +14,36 @@ type Book struct {
 	Opf       *Opf       `json:"opf,omitempty"`
 	TOC       *TOC       `json:"toc,omitempty"`
 	Chapters  []Chapter  `json:"chapters,omitempty"`
+
+	fsys     fs.FS
+	closer   io.Closer
+	epubPath string // reopened on demand when fsys has been released
+	opfPath  string
+	nav      navigation
+
+	// renditions lists the packages of the publication; rendition indexes
+	// the one Opf was read from.
+	renditions  []Rendition
+	rendition   int
+	publication *Metadata // META-INF/metadata.xml
+
+	encryption []EncryptedResource // META-INF/encryption.xml
+	keys       fontKeys            // obfuscation keys of the identifiers as read
+	protection Protection          // DRM detected when the book was read
+	decrypter  Decrypter           // set when ReadOptions.DRM unlocked the book
+	limits     Limits              // ReadOptions.Limits with defaults applied
+
+	// lazy books parse spine documents on demand and cache them by index.
+	lazy  bool
+	spine []chapterRef
+	mu    sync.Mutex
+	cache []*Chapter
+}
+
+// chapterRef locates a spine document whose parsing has been deferred.
+type chapterRef struct {
+	ID   string
+	Href string
 }
 
 var (
@@ -20,9 +53,33 @@ var (
 	// ErrChapterNotFound indicates that the requested chapter could not be
 	// located either by ID or by index.
 	ErrChapterNotFound = errors.New("chapter not found")
+	// ErrBookClosed indicates that the archive backing a book has been
+	// released and content can no longer be loaded from it.
+	ErrBookClosed = errors.New("book is closed")
 )
 
+// Close releases the archive backing the book. Chapters that were already
+// parsed stay available; loading new chapters or resources afterwards fails
+// with ErrBookClosed.
+func (b *Book) Close() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fsys = nil
+	b.epubPath = ""
+	if b.closer == nil {
+		return nil
+	}
+	err := b.closer.Close()
+	b.closer = nil
+	return err
+}
+
 // MetadataValues returns all values for the given Dublin Core metadata key.
+// Keys the package does not declare are looked up in the publication-wide
+// META-INF/metadata.xml.
 func (b *Book) MetadataValues(key string) ([]string, error) {
 	key = strings.ToLower(strings.TrimSpace(key))
 	if key == "" {
@@ -32,6 +89,9 @@ func (b *Book) MetadataValues(key string) ([]string, error) {
 		return nil, fmt.Errorf("%w: %s", ErrMetadataUndefined, key)
 	}
 	values := b.Opf.Metadata.Get(key)
+	if len(values) == 0 {
+		values = b.publication.Get(key)
+	}
 	if len(values) == 0 {
 		return nil, fmt.Errorf("%w: %s", ErrMetadataUndefined, key)
 	}
@@ -70,6 +130,25 @@ func (b *Book) Relation() (string, error)    { return b.metadataGetter("relation
 func (b *Book) Coverage() (string, error)    { return b.metadataGetter("coverage")() }
 func (b *Book) Rights() (string, error)      { return b.metadataGetter("rights")() }
 
+// UniqueIdentifier returns the dc:identifier referenced by the package
+// unique-identifier attribute. Packages without a valid reference fall back to
+// the first identifier.
+func (b *Book) UniqueIdentifier() (string, error) {
+	if b == nil || b.Opf == nil || b.Opf.Metadata == nil {
+		return "", fmt.Errorf("%w: identifier", ErrMetadataUndefined)
+	}
+	if uid := b.Opf.uniqueIdentifier(); uid != "" {
+		for _, ns := range b.Opf.Metadata.Data {
+			for _, entry := range ns["identifier"] {
+				if entry.Attrs["id"] == uid && strings.TrimSpace(entry.Value) != "" {
+					return strings.TrimSpace(entry.Value), nil
+				}
+			}
+		}
+	}
+	return b.Identifier()
+}
+
 // MetadataByKey provides direct access to Dublin Core metadata using a dynamic
 // key. It is a convenience wrapper around MetadataValues and should be used by
 // callers that need to iterate over keys.
@@ -91,19 +170,63 @@ func (b *Book) AllMetadata() map[string][]string {
 	return out
 }
 
-// ChapterCount returns the number of parsed chapters.
+// ChapterCount returns the number of chapters in reading order. For lazily
+// loaded books this does not require parsing any chapter.
 func (b *Book) ChapterCount() int {
 	if b == nil {
 		return 0
 	}
+	if b.lazy {
+		return len(b.spine)
+	}
 	return len(b.Chapters)
 }
 
+// SpineItem is a spine itemref resolved against the manifest.
+type SpineItem struct {
+	IDRef     string `json:"idref"`
+	Path      string `json:"path"`      // container path, "" when the manifest lacks the item
+	MediaType string `json:"mediaType"` // manifest media-type attribute
+	Linear    bool   `json:"linear"`
+}
+
+// SpineItems returns every spine itemref in document order, including
+// non-linear items and items whose files are missing. Unlike the chapter
+// list, indexes into the result match the itemref positions used by EPUB CFI.
+func (b *Book) SpineItems() []SpineItem {
+	if b == nil || b.Opf == nil || b.Opf.Spine == nil {
+		return nil
+	}
+	lookup := b.Opf.Manifest.HrefLookup(b.opfPath)
+	items := make([]SpineItem, 0, len(b.Opf.Spine.Itemrefs))
+	for _, ref := range b.Opf.Spine.Itemrefs {
+		id := strings.TrimSpace(ref.Attrs["idref"])
+		item := SpineItem{
+			IDRef:  id,
+			Linear: !strings.EqualFold(strings.TrimSpace(ref.Attrs["linear"]), "no"),
+		}
+		if href, ok := lookup[id]; ok {
+			item.Path = cleanPath(href)
+		}
+		item.MediaType, _ = b.Opf.Manifest.MediaTypeByID(id)
+		items = append(items, item)
+	}
+	return items
+}
+
 // ChapterByID returns the chapter that matches the provided ID.
 func (b *Book) ChapterByID(id string) (*Chapter, error) {
 	if b == nil {
 		return nil, ErrChapterNotFound
 	}
+	if b.lazy {
+		for i := range b.spine {
+			if b.spine[i].ID == id {
+				return b.loadChapter(i)
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrChapterNotFound, id)
+	}
 	for i := range b.Chapters {
 		if b.Chapters[i].ID == id {
 			return &b.Chapters[i], nil
@@ -114,12 +237,35 @@ func (b *Book) ChapterByID(id string) (*Chapter, error) {
 
 // ChapterByIndex returns the chapter by its ordinal index (0-based).
 func (b *Book) ChapterByIndex(index int) (*Chapter, error) {
-	if b == nil || index < 0 || index >= len(b.Chapters) {
+	if b == nil || index < 0 || index >= b.ChapterCount() {
 		return nil, fmt.Errorf("%w: index %d", ErrChapterNotFound, index)
 	}
+	if b.lazy {
+		return b.loadChapter(index)
+	}
 	return &b.Chapters[index], nil
 }
 
+// loadChapter parses the spine document at index on first access and returns
+// the cached value afterwards.
+func (b *Book) loadChapter(index int) (*Chapter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if chapter := b.cache[index]; chapter != nil {
+		return chapter, nil
+	}
+	if b.fsys == nil {
+		return nil, ErrBookClosed
+	}
+	ref := b.spine[index]
+	chapter, err := parseChapterFile(b.contentFS(b.fsys), ref.ID, ref.Href, b.TOC)
+	if err != nil {
+		return nil, fmt.Errorf("parse chapter %s: %w", ref.ID, err)
+	}
+	b.cache[index] = chapter
+	return chapter, nil
+}
+
 // ChapterTextByID returns the joined text content of the chapter with the
 // provided ID.
 func (b *Book) ChapterTextByID(id string) (string, error) {
@@ -141,19 +287,43 @@ func (b *Book) ChapterTextByIndex(index int) (string, error) {
 }
 
 // AllChaptersText concatenates every chapter's text content in reading order.
+// Chapters that fail to load are skipped; Text reports them instead.
 func (b *Book) AllChaptersText() string {
 	if b == nil {
 		return ""
 	}
 	var texts []string
-	for i := range b.Chapters {
-		if text := b.Chapters[i].Text(); text != "" {
+	for i := 0; i < b.ChapterCount(); i++ {
+		chapter, err := b.ChapterByIndex(i)
+		if err != nil {
+			continue
+		}
+		if text := chapter.Text(); text != "" {
 			texts = append(texts, text)
 		}
 	}
 	return strings.Join(texts, "\n\n")
 }
 
+// Text is AllChaptersText failing with the error of the first chapter that
+// cannot be loaded, as can happen with LazyChapters.
+func (b *Book) Text() (string, error) {
+	if b == nil {
+		return "", nil
+	}
+	var texts []string
+	for i := 0; i < b.ChapterCount(); i++ {
+		chapter, err := b.ChapterByIndex(i)
+		if err != nil {
+			return "", err
+		}
+		if text := chapter.Text(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
 // FlattenTOC returns the table of contents entries as a slice, skipping the
 // synthetic root node if present.
 func (b *Book) FlattenTOC() []TOC {
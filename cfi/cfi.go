@@ -0,0 +1,371 @@
+// Package cfi implements EPUB Canonical Fragment Identifiers (EPUB CFI 1.1).
+//
+// A CFI such as epubcfi(/6/4[chap01ref]!/4[body01]/10[para05]/3:10) walks
+// from the package document through a spine itemref into a content document
+// and down to a node and character offset. Parse and CFI.String convert
+// between the textual and structured forms, Compare orders CFIs in reading
+// order, and Resolve and Generate map CFIs onto an epub.Book.
+package cfi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid indicates that a string is not a well-formed EPUB CFI.
+var ErrInvalid = errors.New("invalid epub cfi")
+
+// Step is a single /N step of a CFI path. Even indexes address child elements
+// (2 is the first element child), odd indexes address the character data
+// before, between or after them.
+type Step struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"` // [id] assertion, unescaped
+	Indirect bool   `json:"indirect"`     // the step follows a ! indirection
+}
+
+// Path is a sequence of steps optionally terminated by a character offset.
+type Path struct {
+	Steps  []Step `json:"steps"`
+	Offset int    `json:"offset"` // character offset in Unicode code points, -1 when absent
+	// TextAssertion holds the text location assertion that may follow the
+	// offset exactly as written, circumflex escapes included, e.g. "yyy,xxx"
+	// for :10[yyy,xxx].
+	TextAssertion string `json:"textAssertion,omitempty"`
+}
+
+// CFI is a parsed EPUB CFI. For a point CFI only Path is set. For a range CFI
+// Path is the common parent and Start and End are the local paths appended to
+// it to obtain the two end points.
+type CFI struct {
+	Path  Path  `json:"path"`
+	Start *Path `json:"start,omitempty"`
+	End   *Path `json:"end,omitempty"`
+}
+
+// IsRange reports whether c is a range CFI.
+func (c CFI) IsRange() bool {
+	return c.Start != nil && c.End != nil
+}
+
+// StartPoint returns the point CFI at which c begins. For a point CFI it is c
+// itself.
+func (c CFI) StartPoint() CFI {
+	if !c.IsRange() {
+		return c
+	}
+	return CFI{Path: joinPaths(c.Path, *c.Start)}
+}
+
+// EndPoint returns the point CFI at which c ends. For a point CFI it is c
+// itself.
+func (c CFI) EndPoint() CFI {
+	if !c.IsRange() {
+		return c
+	}
+	return CFI{Path: joinPaths(c.Path, *c.End)}
+}
+
+// NewRange builds a range CFI spanning start to end. The common leading steps
+// of both points become the parent path.
+func NewRange(start, end CFI) (CFI, error) {
+	if start.IsRange() || end.IsRange() {
+		return CFI{}, fmt.Errorf("%w: range end points must be point cfis", ErrInvalid)
+	}
+	if Compare(start, end) > 0 {
+		start, end = end, start
+	}
+	a, b := start.Path, end.Path
+	n := 0
+	for n < len(a.Steps) && n < len(b.Steps) && a.Steps[n].Index == b.Steps[n].Index &&
+		a.Steps[n].Indirect == b.Steps[n].Indirect {
+		n++
+	}
+	// Both local paths need at least one step or an offset, so the parent
+	// cannot swallow a complete path that ends in the same step.
+	if n == len(a.Steps) && a.Offset < 0 || n == len(b.Steps) && b.Offset < 0 {
+		n--
+	}
+	if n < 1 {
+		return CFI{}, fmt.Errorf("%w: range end points share no parent", ErrInvalid)
+	}
+	parent := Path{Steps: append([]Step(nil), a.Steps[:n]...), Offset: -1}
+	startLocal := Path{Steps: append([]Step(nil), a.Steps[n:]...), Offset: a.Offset, TextAssertion: a.TextAssertion}
+	endLocal := Path{Steps: append([]Step(nil), b.Steps[n:]...), Offset: b.Offset, TextAssertion: b.TextAssertion}
+	return CFI{Path: parent, Start: &startLocal, End: &endLocal}, nil
+}
+
+func joinPaths(parent, local Path) Path {
+	steps := make([]Step, 0, len(parent.Steps)+len(local.Steps))
+	steps = append(steps, parent.Steps...)
+	steps = append(steps, local.Steps...)
+	return Path{Steps: steps, Offset: local.Offset, TextAssertion: local.TextAssertion}
+}
+
+// String returns the canonical textual form, wrapped in epubcfi(...).
+func (c CFI) String() string {
+	var sb strings.Builder
+	sb.WriteString("epubcfi(")
+	c.Path.write(&sb)
+	if c.IsRange() {
+		sb.WriteByte(',')
+		c.Start.write(&sb)
+		sb.WriteByte(',')
+		c.End.write(&sb)
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// String returns the textual form of the path without the epubcfi wrapper.
+func (p Path) String() string {
+	var sb strings.Builder
+	p.write(&sb)
+	return sb.String()
+}
+
+func (p Path) write(sb *strings.Builder) {
+	for _, step := range p.Steps {
+		if step.Indirect {
+			sb.WriteByte('!')
+		}
+		sb.WriteByte('/')
+		sb.WriteString(strconv.Itoa(step.Index))
+		if step.ID != "" {
+			sb.WriteByte('[')
+			sb.WriteString(escape(step.ID))
+			sb.WriteByte(']')
+		}
+	}
+	if p.Offset >= 0 {
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(p.Offset))
+		if p.TextAssertion != "" {
+			sb.WriteByte('[')
+			sb.WriteString(p.TextAssertion)
+			sb.WriteByte(']')
+		}
+	}
+}
+
+// Parse parses a CFI. The epubcfi(...) wrapper and a leading # are optional.
+// Temporal (~) and spatial (@) offsets are not supported.
+func Parse(s string) (CFI, error) {
+	raw := strings.TrimSpace(s)
+	body := strings.TrimPrefix(raw, "#")
+	if strings.HasPrefix(body, "epubcfi(") {
+		if !strings.HasSuffix(body, ")") {
+			return CFI{}, fmt.Errorf("%w: %q: missing closing parenthesis", ErrInvalid, s)
+		}
+		body = body[len("epubcfi(") : len(body)-1]
+	}
+	p := &parser{input: body}
+	parent, err := p.path(true)
+	if err != nil {
+		return CFI{}, fmt.Errorf("%w: %q: %v", ErrInvalid, s, err)
+	}
+	c := CFI{Path: parent}
+	if p.peek() == ',' {
+		if parent.Offset >= 0 {
+			return CFI{}, fmt.Errorf("%w: %q: range parent must not have an offset", ErrInvalid, s)
+		}
+		p.pos++
+		start, err := p.path(false)
+		if err != nil {
+			return CFI{}, fmt.Errorf("%w: %q: %v", ErrInvalid, s, err)
+		}
+		if p.peek() != ',' {
+			return CFI{}, fmt.Errorf("%w: %q: range is missing its end", ErrInvalid, s)
+		}
+		p.pos++
+		end, err := p.path(false)
+		if err != nil {
+			return CFI{}, fmt.Errorf("%w: %q: %v", ErrInvalid, s, err)
+		}
+		c.Start, c.End = &start, &end
+	}
+	if p.pos != len(p.input) {
+		return CFI{}, fmt.Errorf("%w: %q: unexpected %q at %d", ErrInvalid, s, p.input[p.pos], p.pos)
+	}
+	return c, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// path parses steps and an optional offset. A full path must start with a
+// step, a local path (range start or end) may consist of an offset alone.
+func (p *parser) path(full bool) (Path, error) {
+	path := Path{Offset: -1}
+	for {
+		indirect := false
+		if p.peek() == '!' {
+			indirect = true
+			p.pos++
+		}
+		if p.peek() != '/' {
+			if indirect {
+				return Path{}, errors.New("indirection without a step")
+			}
+			break
+		}
+		p.pos++
+		index, err := p.integer()
+		if err != nil {
+			return Path{}, err
+		}
+		step := Step{Index: index, Indirect: indirect}
+		if p.peek() == '[' {
+			assertion, err := p.assertion(false)
+			if err != nil {
+				return Path{}, err
+			}
+			// Step assertions may carry ;key=value parameters after the ID.
+			step.ID, _, _ = strings.Cut(assertion, ";")
+		}
+		path.Steps = append(path.Steps, step)
+	}
+	switch p.peek() {
+	case ':':
+		p.pos++
+		offset, err := p.integer()
+		if err != nil {
+			return Path{}, err
+		}
+		path.Offset = offset
+		if p.peek() == '[' {
+			assertion, err := p.assertion(true)
+			if err != nil {
+				return Path{}, err
+			}
+			path.TextAssertion = assertion
+		}
+	case '~', '@':
+		return Path{}, fmt.Errorf("temporal and spatial offsets are not supported")
+	}
+	if full && len(path.Steps) == 0 {
+		return Path{}, errors.New("path must start with a step")
+	}
+	if len(path.Steps) == 0 && path.Offset < 0 {
+		return Path{}, errors.New("empty path")
+	}
+	return path, nil
+}
+
+func (p *parser) integer() (int, error) {
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+	}
+	digits := p.input[start:p.pos]
+	if digits == "" {
+		return 0, fmt.Errorf("expected integer at %d", start)
+	}
+	if len(digits) > 1 && digits[0] == '0' {
+		return 0, fmt.Errorf("integer %q has a leading zero", digits)
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// assertion reads a bracketed assertion and returns its content. Circumflex
+// escapes are removed unless raw is set, which keeps the escaped , and ;
+// of text assertions distinguishable from their separators.
+func (p *parser) assertion(raw bool) (string, error) {
+	p.pos++ // [
+	var sb strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch c {
+		case '^':
+			if p.pos+1 >= len(p.input) {
+				return "", errors.New("dangling escape")
+			}
+			if raw {
+				sb.WriteByte(c)
+			}
+			sb.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+			continue
+		case ']':
+			p.pos++
+			return sb.String(), nil
+		case '[':
+			return "", fmt.Errorf("unescaped [ at %d", p.pos)
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return "", errors.New("unterminated assertion")
+}
+
+const specialChars = "^[](),;="
+
+func escape(s string) string {
+	if !strings.ContainsAny(s, specialChars) {
+		return s
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(specialChars, r) {
+			sb.WriteByte('^')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Compare orders a and b in reading order and returns -1, 0 or +1. Range CFIs
+// are ordered by their start point, then by their end point. An ancestor
+// sorts before its descendants, and a point without an offset sorts before
+// the same point with one.
+func Compare(a, b CFI) int {
+	if c := comparePaths(a.StartPoint().Path, b.StartPoint().Path); c != 0 {
+		return c
+	}
+	return comparePaths(a.EndPoint().Path, b.EndPoint().Path)
+}
+
+func comparePaths(a, b Path) int {
+	for i := 0; i < len(a.Steps) && i < len(b.Steps); i++ {
+		if c := compareInts(a.Steps[i].Index, b.Steps[i].Index); c != 0 {
+			return c
+		}
+	}
+	if c := compareInts(len(a.Steps), len(b.Steps)); c != 0 {
+		return c
+	}
+	return compareInts(a.Offset, b.Offset)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Sort sorts cfis in reading order.
+func Sort(cfis []CFI) {
+	sort.SliceStable(cfis, func(i, j int) bool {
+		return Compare(cfis[i], cfis[j]) < 0
+	})
+}
@@ -0,0 +1,287 @@
+package cfi
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	epub "github.com/ArcadiaLin/go-epub"
+)
+
+// ErrNotFound indicates that a CFI does not address an existing node of the
+// book.
+var ErrNotFound = errors.New("cfi target not found")
+
+// Location is a position inside a book: a spine item, a node of its content
+// document and, for text nodes, a character offset.
+type Location struct {
+	SpineIndex int    // itemref position in the spine, counting non-linear items
+	IDRef      string // manifest ID of the spine item
+	Path       string // container path of the content document
+
+	// Document is the root element of the content document as returned by
+	// Book.OpenDocument. Node must belong to this tree.
+	Document *epub.HtmlNode
+	Node     *epub.HtmlNode
+	// Offset is the character offset into Node in Unicode code points, or -1
+	// when the location addresses the node as a whole.
+	Offset int
+}
+
+// Text returns the text that follows the location inside its text node.
+func (l Location) Text() string {
+	if l.Node == nil || l.Node.Type != epub.TextNode {
+		return ""
+	}
+	offset := max(l.Offset, 0)
+	content := l.Node.Content
+	for i := range content {
+		if offset == 0 {
+			return content[i:]
+		}
+		offset--
+	}
+	return ""
+}
+
+// Resolve maps a point CFI onto book. For a range CFI the start point is
+// resolved; use ResolveRange to obtain both ends.
+//
+// An odd step that names an empty character-data slot (for example /1 before
+// a leading element) resolves to the enclosing element with offset -1.
+func Resolve(book *epub.Book, c CFI) (Location, error) {
+	path := c.StartPoint().Path
+	split := indirection(path)
+	if split < 0 {
+		return Location{}, fmt.Errorf("%w: %s does not enter a content document", ErrNotFound, c)
+	}
+	loc, err := resolveSpine(book, path.Steps[:split])
+	if err != nil {
+		return Location{}, err
+	}
+	doc, err := book.OpenDocument(loc.Path)
+	if err != nil {
+		return Location{}, fmt.Errorf("parse %s: %w", loc.Path, err)
+	}
+	loc.Document = doc
+	return resolveInDocument(loc, path.Steps[split:], path.Offset)
+}
+
+// ResolveRange maps both end points of a range CFI onto book.
+func ResolveRange(book *epub.Book, c CFI) (start, end Location, err error) {
+	if !c.IsRange() {
+		return Location{}, Location{}, fmt.Errorf("%w: %s is not a range", ErrInvalid, c)
+	}
+	if start, err = Resolve(book, c.StartPoint()); err != nil {
+		return Location{}, Location{}, err
+	}
+	if end, err = Resolve(book, c.EndPoint()); err != nil {
+		return Location{}, Location{}, err
+	}
+	return start, end, nil
+}
+
+// indirection returns the index of the first step that enters a content
+// document, or -1.
+func indirection(p Path) int {
+	for i, step := range p.Steps {
+		if step.Indirect {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveSpine follows the package document steps, /6/4[idref] in the common
+// case, to a spine item.
+func resolveSpine(book *epub.Book, steps []Step) (Location, error) {
+	if len(steps) != 2 {
+		return Location{}, fmt.Errorf("%w: expected spine and itemref steps before the indirection", ErrNotFound)
+	}
+	spineStep, ok := spineStepIndex(book)
+	if !ok || steps[0].Index != spineStep {
+		return Location{}, fmt.Errorf("%w: step /%d is not the spine", ErrNotFound, steps[0].Index)
+	}
+	items := book.SpineItems()
+	index := -1
+	if i := steps[1].Index/2 - 1; steps[1].Index%2 == 0 && i >= 0 && i < len(items) {
+		index = i
+	}
+	// The ID assertion wins when the itemref moved, as the spec recommends.
+	if id := steps[1].ID; id != "" && (index < 0 || items[index].IDRef != id) {
+		index = -1
+		for i := range items {
+			if items[i].IDRef == id {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 {
+		return Location{}, fmt.Errorf("%w: spine item /%d", ErrNotFound, steps[1].Index)
+	}
+	item := items[index]
+	if item.Path == "" {
+		return Location{}, fmt.Errorf("%w: spine item %s has no manifest entry", ErrNotFound, item.IDRef)
+	}
+	return Location{SpineIndex: index, IDRef: item.IDRef, Path: item.Path}, nil
+}
+
+// spineStepIndex returns the CFI step of the spine element inside the package
+// element.
+func spineStepIndex(book *epub.Book) (int, bool) {
+	if book == nil || book.Opf == nil || book.Opf.XmlNode == nil {
+		return 0, false
+	}
+	for i, child := range book.Opf.XmlNode.XmlNodes {
+		if child.XMLName.Local == "spine" {
+			return 2 * (i + 1), true
+		}
+	}
+	return 0, false
+}
+
+func resolveInDocument(loc Location, steps []Step, offset int) (Location, error) {
+	node := loc.Document
+	for _, step := range steps {
+		child, ok := childAt(node, step.Index)
+		if step.ID != "" {
+			if byID := findByID(loc.Document, step.ID); byID != nil && byID != child {
+				child, ok = byID, true
+			}
+		}
+		if !ok {
+			return Location{}, fmt.Errorf("%w: step /%d in %s", ErrNotFound, step.Index, loc.Path)
+		}
+		if child == nil {
+			// Empty character data slot: the position sits directly in node.
+			loc.Node, loc.Offset = node, -1
+			return loc, nil
+		}
+		node = child
+	}
+	loc.Node = node
+	loc.Offset = offset
+	if offset >= 0 && node.Type == epub.TextNode && offset > utf8.RuneCountInString(node.Content) {
+		return Location{}, fmt.Errorf("%w: offset %d beyond text length in %s", ErrNotFound, offset, loc.Path)
+	}
+	return loc, nil
+}
+
+// childAt returns the child addressed by a CFI step index. ok is false when
+// the index is out of range; a nil child with ok set marks an empty
+// character-data slot.
+func childAt(node *epub.HtmlNode, index int) (child *epub.HtmlNode, ok bool) {
+	if node == nil || index < 1 {
+		return nil, false
+	}
+	elements := 0
+	for _, c := range node.Children {
+		if c.Type == epub.ElementNode {
+			elements++
+			if index == 2*elements {
+				return c, true
+			}
+			continue
+		}
+		if index == 2*elements+1 {
+			return c, true
+		}
+	}
+	if index%2 == 1 && index <= 2*elements+1 {
+		return nil, true
+	}
+	return nil, false
+}
+
+// stepIndex returns the CFI step index of child within parent.
+func stepIndex(parent, child *epub.HtmlNode) int {
+	elements := 0
+	for _, c := range parent.Children {
+		if c.Type == epub.ElementNode {
+			elements++
+		}
+		if c == child {
+			if c.Type == epub.ElementNode {
+				return 2 * elements
+			}
+			return 2*elements + 1
+		}
+	}
+	return -1
+}
+
+func findByID(node *epub.HtmlNode, id string) *epub.HtmlNode {
+	if node == nil || node.Type != epub.ElementNode {
+		return nil
+	}
+	if v, ok := node.Attrs["id"]; ok && v == id {
+		return node
+	}
+	for _, c := range node.Children {
+		if found := findByID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// ancestry returns the nodes from root down to target, inclusive.
+func ancestry(root, target *epub.HtmlNode) []*epub.HtmlNode {
+	if root == nil {
+		return nil
+	}
+	if root == target {
+		return []*epub.HtmlNode{root}
+	}
+	for _, c := range root.Children {
+		if chain := ancestry(c, target); chain != nil {
+			return append([]*epub.HtmlNode{root}, chain...)
+		}
+	}
+	return nil
+}
+
+// Generate builds the point CFI for loc. Element steps carry [id] assertions
+// when the element has an id attribute, and the itemref step carries the
+// spine item's idref.
+func Generate(book *epub.Book, loc Location) (CFI, error) {
+	spineStep, ok := spineStepIndex(book)
+	if !ok {
+		return CFI{}, fmt.Errorf("%w: package has no spine", ErrNotFound)
+	}
+	items := book.SpineItems()
+	if loc.SpineIndex < 0 || loc.SpineIndex >= len(items) {
+		return CFI{}, fmt.Errorf("%w: spine index %d", ErrNotFound, loc.SpineIndex)
+	}
+	chain := ancestry(loc.Document, loc.Node)
+	if len(chain) < 2 {
+		return CFI{}, fmt.Errorf("%w: node is not below the document's root element", ErrNotFound)
+	}
+	steps := []Step{
+		{Index: spineStep},
+		{Index: 2 * (loc.SpineIndex + 1), ID: items[loc.SpineIndex].IDRef},
+	}
+	for i := 1; i < len(chain); i++ {
+		node := chain[i]
+		step := Step{Index: stepIndex(chain[i-1], node), Indirect: i == 1}
+		if node.Type == epub.ElementNode {
+			step.ID = node.Attrs["id"]
+		}
+		steps = append(steps, step)
+	}
+	return CFI{Path: Path{Steps: steps, Offset: loc.Offset}}, nil
+}
+
+// GenerateRange builds a range CFI from start to end.
+func GenerateRange(book *epub.Book, start, end Location) (CFI, error) {
+	a, err := Generate(book, start)
+	if err != nil {
+		return CFI{}, err
+	}
+	b, err := Generate(book, end)
+	if err != nil {
+		return CFI{}, err
+	}
+	return NewRange(a, b)
+}
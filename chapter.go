@@ -4,20 +4,69 @@ import (
 	"archive/zip"
 	"errors"
 	"fmt"
-	"path/filepath"
+	"io"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 type Chapter struct {
-	ID         string
-	Path       string
-	Title      string
-	Paragraphs []string
-	Images     []string
+	ID    string `json:"id"`
+	Path  string `json:"path"`
+	Title string `json:"title,omitempty"`
+	// TitleSource records where Title was taken from.
+	TitleSource TitleSource `json:"titleSource"`
+	// Blocks holds the structured content of the chapter body.
+	Blocks []Block `json:"blocks,omitempty"`
+	// Paragraphs is the plain-text projection of Blocks, one entry per
+	// top-level block.
+	Paragraphs []string `json:"paragraphs,omitempty"`
+	Images     []string `json:"images,omitempty"`
+}
+
+// TitleSource identifies where a chapter title comes from, from most to least
+// reliable.
+type TitleSource int
+
+const (
+	TitleSourceNone     TitleSource = iota // no title found
+	TitleSourceTOC                         // the TOC entry pointing at the start of the chapter
+	TitleSourceHeading                     // the first h1–h3 of the body
+	TitleSourceDocument                    // the document <title>
+)
+
+var titleSourceNames = [...]string{
+	TitleSourceNone:     "none",
+	TitleSourceTOC:      "toc",
+	TitleSourceHeading:  "heading",
+	TitleSourceDocument: "document",
+}
+
+func (s TitleSource) String() string {
+	if s >= 0 && int(s) < len(titleSourceNames) {
+		return titleSourceNames[s]
+	}
+	return "TitleSource(" + strconv.Itoa(int(s)) + ")"
+}
+
+// MarshalText encodes the source by name so JSON output stays readable.
+func (s TitleSource) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a source encoded by MarshalText.
+func (s *TitleSource) UnmarshalText(text []byte) error {
+	i := slices.Index(titleSourceNames[:], string(text))
+	if i < 0 {
+		return fmt.Errorf("unknown title source %q", text)
+	}
+	*s = TitleSource(i)
+	return nil
 }
 
 // Text joins all extracted paragraphs into a single string separated by blank
-// lines. The returned value is suitable for plain-text readers.
+// lines. The returned value is suitable for plain-text readers and is a
+// projection of Blocks.
 func (c *Chapter) Text() string {
 	if c == nil || len(c.Paragraphs) == 0 {
 		return ""
@@ -37,49 +86,130 @@ func (c *Chapter) Clone() Chapter {
 		return Chapter{}
 	}
 	clone := Chapter{
-		ID:    c.ID,
-		Path:  c.Path,
-		Title: c.Title,
+		ID:          c.ID,
+		Path:        c.Path,
+		Title:       c.Title,
+		TitleSource: c.TitleSource,
 	}
+	clone.Blocks = cloneBlocks(c.Blocks)
 	clone.Paragraphs = append(clone.Paragraphs, c.Paragraphs...)
 	clone.Images = append(clone.Images, c.Images...)
 	return clone
 }
 
-func ParseChapter(id, href string, f *zip.File) (*Chapter, error) {
+// ParseChapter parses the XHTML chapter document stored in the archive entry
+// f, as ParseChapterFromReader does.
+func ParseChapter(id, href string, f *zip.File) (chapter *Chapter, err error) {
 	if f == nil {
 		return nil, fmt.Errorf("nil chapter file reference")
 	}
-
-	rc, err := f.Open()
+	rc, err := zipEntryFS(f).Open(f.Name)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		err = errors.Join(err, rc.Close())
 	}()
+	return ParseChapterFromReader(id, href, rc)
+}
+
+// ParseChapterFromReader parses the XHTML chapter document read from r. The
+// href is the document path inside the container and is used to resolve image
+// references. The title is the first h1–h3 of the body, or the document
+// <title>; books read with ReadBook prefer the TOC entry of the chapter.
+func ParseChapterFromReader(id, href string, r io.Reader) (*Chapter, error) {
+	chapter, _, err := parseChapterTree(id, href, r, DefaultMaxDepth)
+	return chapter, err
+}
+
+// parseChapterTree is ParseChapterFromReader returning the parsed document as well.
+// Documents nesting deeper than maxDepth are rejected.
+func parseChapterTree(id, href string, r io.Reader, maxDepth int) (*Chapter, *HtmlNode, error) {
+	if r == nil {
+		return nil, nil, fmt.Errorf("nil chapter reader")
+	}
 
-	root, err := ParseHTML(rc)
+	root, err := parseHTML(r, maxDepth)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	title := findFirstText(root, "title")
 	body := root.FindNode("body")
 	if body == nil {
 		body = root
 	}
 
-	paragraphs := extractParagraphs(body)
-	images := extractImages(body, filepath.Dir(href))
+	builder := &blockBuilder{base: pathDir(href)}
+	blocks := builder.buildBlocks(body)
+	images := extractImages(body, pathDir(href))
 
-	return &Chapter{
+	chapter := &Chapter{
 		ID:         id,
 		Path:       href,
-		Title:      title,
-		Paragraphs: paragraphs,
+		Blocks:     blocks,
+		Paragraphs: extractParagraphs(blocks),
 		Images:     images,
-	}, nil
+	}
+	if title := firstHeading(body); title != "" {
+		chapter.Title, chapter.TitleSource = title, TitleSourceHeading
+	} else if title := collapseSpace(findFirstText(root, "title")); title != "" {
+		chapter.Title, chapter.TitleSource = title, TitleSourceDocument
+	}
+	return chapter, root, nil
+}
+
+// firstHeading returns the text of the first non-empty h1, h2 or h3 below n.
+func firstHeading(n *HtmlNode) string {
+	if n.Type == ElementNode {
+		switch n.Name {
+		case "h1", "h2", "h3":
+			if text := collapseSpace(n.NodeText()); text != "" {
+				return text
+			}
+			return ""
+		}
+		if skippedElements[n.Name] {
+			return ""
+		}
+	}
+	for _, c := range n.Children {
+		if text := firstHeading(c); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// useTOCTitle replaces the chapter title with the title of the TOC entry
+// pointing at the start of the document: an entry without fragment, or one
+// whose anchor precedes all content of root, the parsed document.
+func (c *Chapter) useTOCTitle(toc *TOC, root *HtmlNode) {
+	if toc == nil {
+		return
+	}
+	type anchoredTitle struct{ title, fragment string }
+	var anchored []anchoredTitle
+	for _, entry := range toc.Flatten() {
+		docPath, fragment := entry.target()
+		if docPath != c.Path || entry.Title == "" {
+			continue
+		}
+		if fragment == "" {
+			c.Title, c.TitleSource = entry.Title, TitleSourceTOC
+			return
+		}
+		anchored = append(anchored, anchoredTitle{entry.Title, fragment})
+	}
+	if len(anchored) == 0 {
+		return
+	}
+	anchors := newAnchorIndex(root, c.Path)
+	for _, a := range anchored {
+		if loc, ok := anchors.locate(a.fragment); ok && loc.block == 0 {
+			c.Title, c.TitleSource = a.title, TitleSourceTOC
+			return
+		}
+	}
 }
 
 // 辅助函数
@@ -106,19 +236,12 @@ func findFirstText(n *HtmlNode, name string) string {
 	return node.NodeText()
 }
 
-func extractParagraphs(body *HtmlNode) []string {
-	if body == nil {
-		return nil
-	}
+// extractParagraphs projects blocks onto plain-text paragraphs.
+func extractParagraphs(blocks []Block) []string {
 	var res []string
-	for _, c := range body.Children {
-		if c.Type == ElementNode && (c.Name == "p" || c.Name == "div") {
-			txt := c.NodeText()
-			if txt != "" {
-				res = append(res, txt)
-			}
-		} else {
-			res = append(res, extractParagraphs(c)...)
+	for _, b := range blocks {
+		if txt := b.PlainText(); txt != "" {
+			res = append(res, txt)
 		}
 	}
 	return res
@@ -129,9 +252,17 @@ func extractImages(body *HtmlNode, base string) []string {
 		return nil
 	}
 	var res []string
-	if body.Type == ElementNode && body.Name == "img" {
-		if src, ok := body.Attrs["src"]; ok {
-			res = append(res, filepath.Join(base, src))
+	if body.Type == ElementNode {
+		var src string
+		switch body.Name {
+		case "img":
+			src = body.Attrs["src"]
+		case "image":
+			// SVG <image xlink:href="..."> is common on cover pages.
+			src = body.Attrs["href"]
+		}
+		if src = strings.TrimSpace(src); src != "" && !isExternalRef(src) {
+			res = append(res, cleanPath(resolveRelative(base, src)))
 		}
 	}
 	for _, c := range body.Children {
@@ -139,3 +270,10 @@ func extractImages(body *HtmlNode, base string) []string {
 	}
 	return res
 }
+
+// isExternalRef reports whether ref points outside the container (remote URL
+// or data URI) and therefore cannot be resolved to an archive entry.
+func isExternalRef(ref string) bool {
+	lower := strings.ToLower(ref)
+	return strings.HasPrefix(lower, "data:") || strings.Contains(lower, "://")
+}
@@ -0,0 +1,92 @@
+package epub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// sniffLen is the number of leading bytes inspected for encoding declarations.
+const sniffLen = 1024
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+
+	xmlDeclEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*?encoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+	metaCharset     = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?([A-Za-z0-9._:-]+)`)
+)
+
+// charsetReader 将声明的编码转换为 UTF-8 / charsetReader converts input in the named charset to UTF-8.
+// It is installed as xml.Decoder.CharsetReader and understands every label
+// of the WHATWG encoding standard (GBK, Big5, Shift_JIS, windows-1252, ...).
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	}
+	enc, _ := charset.Lookup(label)
+	if enc == nil {
+		return nil, fmt.Errorf("unsupported charset: %s", label)
+	}
+	return transform.NewReader(input, enc.NewDecoder()), nil
+}
+
+// utf8Passthrough is used once a stream has already been converted to UTF-8,
+// so that the encoding named in its XML declaration is not applied twice.
+func utf8Passthrough(_ string, input io.Reader) (io.Reader, error) {
+	return input, nil
+}
+
+// sniffBOM 处理字节序标记 / sniffBOM strips a UTF-8 byte order mark and converts UTF-16 input (with a
+// BOM or an unmarked "<?" prefix) to UTF-8. converted reports whether the
+// returned reader no longer matches the declared encoding.
+func sniffBOM(r io.Reader) (out io.Reader, converted bool) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		_, _ = br.Discard(len(bomUTF8))
+		return br, false
+	case bytes.HasPrefix(head, bomUTF16LE), bytes.HasPrefix(head, []byte{'<', 0, '?', 0}):
+		return transform.NewReader(br, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()), true
+	case bytes.HasPrefix(head, bomUTF16BE), bytes.HasPrefix(head, []byte{0, '<', 0, '?'}):
+		return transform.NewReader(br, unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()), true
+	}
+	return br, false
+}
+
+// newHTMLReader 将 HTML/XHTML 转换为 UTF-8 / newHTMLReader returns a UTF-8 view of an HTML or XHTML document.
+// The encoding is taken from the byte order mark, the XML declaration or a
+// <meta charset> / http-equiv declaration, in that order; UTF-8 is assumed
+// when none is present.
+func newHTMLReader(r io.Reader) (io.Reader, error) {
+	r, converted := sniffBOM(r)
+	if converted {
+		return r, nil
+	}
+	br := r.(*bufio.Reader)
+	head, _ := br.Peek(sniffLen)
+	label := declaredEncoding(head)
+	return charsetReader(label, br)
+}
+
+// declaredEncoding returns the encoding named by the XML declaration or a
+// meta element in head.
+func declaredEncoding(head []byte) string {
+	if m := xmlDeclEncoding.FindSubmatch(head); m != nil {
+		return string(m[1])
+	}
+	if m := metaCharset.FindSubmatch(head); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
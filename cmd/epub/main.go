@@ -0,0 +1,356 @@
+// Command epub inspects EPUB publications from the command line.
+//
+// Usage:
+//
+//	epub <command> [--json] <book> [args]
+//
+// The book may be an .epub archive or an exploded (unzipped) directory. Every
+// command accepts --json to emit machine-readable output.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ArcadiaLin/go-epub"
+	"github.com/ArcadiaLin/go-epub/lcp"
+)
+
+type command struct {
+	name  string
+	args  string
+	help  string
+	run   func(ctx *context) error
+	nargs int // positional arguments after the book
+}
+
+var commands = []command{
+	{name: "info", help: "show publication metadata", run: runInfo},
+	{name: "toc", help: "print the table of contents", run: runTOC},
+	{name: "cat", args: "<chapter>", help: "print a chapter by index or ID", run: runCat, nargs: 1},
+	{name: "ls", help: "list manifest resources", run: runLs},
+	{name: "extract", args: "<href>", help: "write a resource to stdout or --out", run: runExtract, nargs: 1},
+	{name: "cover", help: "write the cover image to stdout or --out", run: runCover},
+	{name: "validate", help: "check the publication for spec violations", run: runValidate},
+	{name: "text", help: "print the plain text of the whole book", run: runText},
+}
+
+// context carries the parsed flags and arguments of a command invocation.
+type context struct {
+	bookPath   string
+	args       []string
+	json       bool
+	out        string
+	passphrase string // unlocks books protected by Readium LCP
+	stdout     io.Writer
+}
+
+// errInvalid signals a failed validation; it only affects the exit status.
+var errInvalid = errors.New("publication is not valid")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, errInvalid) {
+			fmt.Fprintln(os.Stderr, "epub:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(argv []string, stdout io.Writer) error {
+	if len(argv) == 0 || argv[0] == "-h" || argv[0] == "--help" || argv[0] == "help" {
+		usage(os.Stderr)
+		if len(argv) == 0 {
+			return errors.New("missing command")
+		}
+		return nil
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == argv[0] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage(os.Stderr)
+		return fmt.Errorf("unknown command %q", argv[0])
+	}
+
+	ctx := &context{stdout: stdout}
+	fset := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fset.BoolVar(&ctx.json, "json", false, "emit JSON output")
+	fset.StringVar(&ctx.passphrase, "passphrase", "", "passphrase of a Readium LCP protected book")
+	if cmd.name == "extract" || cmd.name == "cover" {
+		fset.StringVar(&ctx.out, "out", "", "write to this file instead of stdout")
+	}
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: epub %s [flags] <book> %s\n", cmd.name, cmd.args)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(argv[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fset.NArg() != 1+cmd.nargs {
+		fset.Usage()
+		return fmt.Errorf("%s: expected %d argument(s)", cmd.name, 1+cmd.nargs)
+	}
+	ctx.bookPath = fset.Arg(0)
+	ctx.args = fset.Args()[1:]
+	return cmd.run(ctx)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: epub <command> [--json] <book> [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %-10s %s\n", c.name, c.args, c.help)
+	}
+}
+
+// openBook opens an .epub archive or an exploded book directory, unlocking it
+// with passphrase when one is given.
+func openBook(bookPath, passphrase string) (*epub.Book, error) {
+	info, err := os.Stat(bookPath)
+	if err != nil {
+		return nil, err
+	}
+	opts := epub.ReadOptions{LazyChapters: true}
+	if passphrase != "" {
+		opts.DRM = lcp.Passphrase(passphrase)
+	}
+	if info.IsDir() {
+		return epub.ReadBookFS(os.DirFS(bookPath), opts)
+	}
+	return epub.ReadBook(bookPath, opts)
+}
+
+func withBook(ctx *context, fn func(*epub.Book) error) (err error) {
+	book, err := openBook(ctx.bookPath, ctx.passphrase)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, book.Close())
+	}()
+	return fn(book)
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runInfo(ctx *context) error {
+	return withBook(ctx, func(book *epub.Book) error {
+		metadata := book.AllMetadata()
+		var series *epub.Series
+		if s, err := book.Series(); err == nil {
+			series = &s
+		}
+		if ctx.json {
+			return printJSON(ctx.stdout, struct {
+				Chapters int                 `json:"chapters"`
+				Series   *epub.Series        `json:"series,omitempty"`
+				Metadata map[string][]string `json:"metadata"`
+			}{book.ChapterCount(), series, metadata})
+		}
+		for _, key := range []string{"title", "creator", "language", "identifier", "publisher", "date"} {
+			if values := metadata[key]; len(values) > 0 {
+				fmt.Fprintf(ctx.stdout, "%-11s %s\n", key+":", strings.Join(values, "; "))
+			}
+		}
+		if series != nil {
+			name := series.Name
+			if series.Index != 0 {
+				name += " #" + strconv.FormatFloat(series.Index, 'f', -1, 64)
+			}
+			fmt.Fprintf(ctx.stdout, "%-11s %s\n", "series:", name)
+		}
+		fmt.Fprintf(ctx.stdout, "%-11s %d\n", "chapters:", book.ChapterCount())
+		keys := make([]string, 0, len(metadata))
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintln(ctx.stdout, "\nall metadata:")
+		for _, k := range keys {
+			fmt.Fprintf(ctx.stdout, "  %s: %s\n", k, strings.Join(metadata[k], "; "))
+		}
+		return nil
+	})
+}
+
+func runTOC(ctx *context) error {
+	return withBook(ctx, func(book *epub.Book) error {
+		var entries []epub.TOC
+		if book.TOC != nil {
+			entries = book.TOC.Children
+		}
+		if ctx.json {
+			return printJSON(ctx.stdout, entries)
+		}
+		var walk func([]epub.TOC, int)
+		walk = func(list []epub.TOC, depth int) {
+			for _, e := range list {
+				fmt.Fprintf(ctx.stdout, "%s%s (%s)\n", strings.Repeat("  ", depth), e.Title, e.Href)
+				walk(e.Children, depth+1)
+			}
+		}
+		walk(entries, 0)
+		return nil
+	})
+}
+
+func runCat(ctx *context) error {
+	return withBook(ctx, func(book *epub.Book) error {
+		ref := ctx.args[0]
+		chapter, err := book.ChapterByID(ref)
+		if errors.Is(err, epub.ErrChapterNotFound) {
+			if index, convErr := strconv.Atoi(ref); convErr == nil {
+				chapter, err = book.ChapterByIndex(index)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.json {
+			return printJSON(ctx.stdout, chapter)
+		}
+		_, err = fmt.Fprintln(ctx.stdout, chapter.Text())
+		return err
+	})
+}
+
+func runLs(ctx *context) error {
+	return withBook(ctx, func(book *epub.Book) error {
+		resources, err := book.Resources()
+		if err != nil {
+			return err
+		}
+		if ctx.json {
+			return printJSON(ctx.stdout, resources)
+		}
+		for _, r := range resources {
+			fmt.Fprintf(ctx.stdout, "%-24s %10d  %-28s %s", r.ID, r.Size, r.MediaType, r.Path)
+			if len(r.Properties) > 0 {
+				fmt.Fprintf(ctx.stdout, "  [%s]", strings.Join(r.Properties, " "))
+			}
+			fmt.Fprintln(ctx.stdout)
+		}
+		return nil
+	})
+}
+
+func runExtract(ctx *context) error {
+	return withBook(ctx, func(book *epub.Book) error {
+		rc, err := book.OpenResource(ctx.args[0])
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return emitBytes(ctx, rc, map[string]any{"path": ctx.args[0]})
+	})
+}
+
+func runCover(ctx *context) error {
+	return withBook(ctx, func(book *epub.Book) error {
+		cover, rc, err := book.Cover()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return emitBytes(ctx, rc, map[string]any{
+			"path":      cover.Href,
+			"mediaType": cover.MediaType,
+			"source":    cover.Source,
+		})
+	})
+}
+
+// emitBytes copies r to --out or stdout. With --json the bytes are embedded
+// base64-encoded next to info, unless --out is given.
+func emitBytes(ctx *context, r io.Reader, info map[string]any) error {
+	if ctx.out != "" {
+		n, err := writeFile(ctx.out, r)
+		if err != nil || !ctx.json {
+			return err
+		}
+		info["size"] = n
+		info["out"] = ctx.out
+		return printJSON(ctx.stdout, info)
+	}
+	if !ctx.json {
+		_, err := io.Copy(ctx.stdout, r)
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	info["size"] = len(data)
+	info["data"] = base64.StdEncoding.EncodeToString(data)
+	return printJSON(ctx.stdout, info)
+}
+
+// writeFile copies r to the file name. Errors closing the file are returned,
+// as they may mean the data never reached the disk.
+func writeFile(name string, r io.Reader) (int64, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	return n, errors.Join(err, f.Close())
+}
+
+func runValidate(ctx *context) error {
+	var report epub.Report
+	if info, err := os.Stat(ctx.bookPath); err == nil && info.IsDir() {
+		report = epub.ValidateFS(os.DirFS(ctx.bookPath))
+	} else {
+		report = epub.Validate(ctx.bookPath)
+	}
+	if ctx.json {
+		if err := printJSON(ctx.stdout, struct {
+			Valid bool `json:"valid"`
+			epub.Report
+		}{report.Valid(), report}); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range report.Findings {
+			fmt.Fprintln(ctx.stdout, f)
+		}
+		fmt.Fprintf(ctx.stdout, "%d error(s), %d warning(s)\n", len(report.Errors()), len(report.Warnings()))
+	}
+	if !report.Valid() {
+		return errInvalid
+	}
+	return nil
+}
+
+func runText(ctx *context) error {
+	return withBook(ctx, func(book *epub.Book) error {
+		text, err := book.Text()
+		if err != nil {
+			return err
+		}
+		if ctx.json {
+			return printJSON(ctx.stdout, map[string]string{"text": text})
+		}
+		_, err = fmt.Fprintln(ctx.stdout, text)
+		return err
+	})
+}
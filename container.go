@@ -47,16 +47,12 @@ func ParseContainer(content []byte) (*Container, error) {
 	return container, nil
 }
 
+// FindOpfFile returns the package document path of the default (first)
+// rendition. Renditions lists all of them.
 func (c *Container) FindOpfFile() (string, error) {
-	for _, rf := range c.Rootfiles {
-		fullPath := strings.TrimSpace(rf.Attrs["full-path"])
-		if fullPath == "" {
-			continue
-		}
-		mediaType := strings.TrimSpace(rf.Attrs["media-type"])
-		if strings.EqualFold(mediaType, "application/oebps-package+xml") || strings.EqualFold(mediaType, "application/epub+zip") || mediaType == "" {
-			return fullPath, nil
-		}
+	renditions := c.Renditions()
+	if len(renditions) == 0 {
+		return "", errors.New("no root file found")
 	}
-	return "", errors.New("no root file found")
+	return renditions[0].Path, nil
 }
@@ -0,0 +1,641 @@
+package epub
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ArcadiaLin/go-epub/internal/cjk"
+)
+
+// BlockKind identifies the type of a structural block in chapter content.
+type BlockKind int
+
+const (
+	BlockParagraph BlockKind = iota
+	BlockHeading
+	BlockList
+	BlockQuote
+	BlockTable
+	BlockFigure
+	BlockPreformatted
+	BlockRule
+)
+
+var blockKindNames = [...]string{
+	BlockParagraph:    "paragraph",
+	BlockHeading:      "heading",
+	BlockList:         "list",
+	BlockQuote:        "quote",
+	BlockTable:        "table",
+	BlockFigure:       "figure",
+	BlockPreformatted: "preformatted",
+	BlockRule:         "rule",
+}
+
+func (k BlockKind) String() string {
+	if k >= 0 && int(k) < len(blockKindNames) {
+		return blockKindNames[k]
+	}
+	return "BlockKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// MarshalText encodes the kind by name so JSON output stays readable.
+func (k BlockKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes a kind encoded by MarshalText.
+func (k *BlockKind) UnmarshalText(text []byte) error {
+	i := slices.Index(blockKindNames[:], string(text))
+	if i < 0 {
+		return fmt.Errorf("unknown block kind %q", text)
+	}
+	*k = BlockKind(i)
+	return nil
+}
+
+// InlineStyle is a bit set of character level formatting.
+type InlineStyle uint8
+
+const (
+	StyleBold InlineStyle = 1 << iota
+	StyleItalic
+	StyleCode
+)
+
+// Inline is a run of text sharing the same formatting. Inline images carry the
+// container path of the image in Image and the alt text in Text.
+type Inline struct {
+	Text  string      `json:"text,omitempty"`
+	Style InlineStyle `json:"style,omitempty"`
+	Href  string      `json:"href,omitempty"`  // link target as written in the document
+	Image string      `json:"image,omitempty"` // resolved image path for inline images
+}
+
+// TableCell is a single cell of a table row.
+type TableCell struct {
+	Header  bool     `json:"header,omitempty"`
+	Inlines []Inline `json:"inlines,omitempty"`
+}
+
+// Block is a structural unit of chapter content. Which fields are populated
+// depends on Kind:
+//
+//   - BlockParagraph, BlockHeading: Inlines (Level holds the heading level)
+//   - BlockList: Items, Ordered
+//   - BlockQuote: Children
+//   - BlockTable: Rows
+//   - BlockFigure: Inlines (images) and Caption
+//   - BlockPreformatted: Text
+type Block struct {
+	Kind     BlockKind     `json:"kind"`
+	ID       string        `json:"id,omitempty"` // id attribute of the source element
+	Level    int           `json:"level,omitempty"`
+	Ordered  bool          `json:"ordered,omitempty"`
+	Inlines  []Inline      `json:"inlines,omitempty"`
+	Items    [][]Block     `json:"items,omitempty"`
+	Children []Block       `json:"children,omitempty"`
+	Rows     [][]TableCell `json:"rows,omitempty"`
+	Caption  []Inline      `json:"caption,omitempty"`
+	Text     string        `json:"text,omitempty"`
+}
+
+// PlainText projects the block onto plain text. List items and table rows are
+// placed on separate lines; table cells are separated by tabs.
+func (b Block) PlainText() string {
+	switch b.Kind {
+	case BlockParagraph, BlockHeading:
+		return inlinesText(b.Inlines)
+	case BlockFigure:
+		return inlinesText(b.Caption)
+	case BlockPreformatted:
+		return b.Text
+	case BlockQuote:
+		return blocksText(b.Children, "\n\n")
+	case BlockList:
+		var lines []string
+		for _, item := range b.Items {
+			if text := blocksText(item, "\n"); text != "" {
+				lines = append(lines, text)
+			}
+		}
+		return strings.Join(lines, "\n")
+	case BlockTable:
+		var lines []string
+		for _, row := range b.Rows {
+			cells := make([]string, len(row))
+			for i, cell := range row {
+				cells[i] = inlinesText(cell.Inlines)
+			}
+			lines = append(lines, strings.Join(cells, "\t"))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
+
+func inlinesText(inlines []Inline) string {
+	var sb strings.Builder
+	for _, in := range inlines {
+		if in.Image == "" {
+			sb.WriteString(in.Text)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func blocksText(blocks []Block, sep string) string {
+	var parts []string
+	for _, b := range blocks {
+		if text := b.PlainText(); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// cloneBlocks returns a deep copy of blocks.
+func cloneBlocks(blocks []Block) []Block {
+	if blocks == nil {
+		return nil
+	}
+	out := make([]Block, len(blocks))
+	for i, b := range blocks {
+		c := b
+		c.Inlines = append([]Inline(nil), b.Inlines...)
+		c.Caption = append([]Inline(nil), b.Caption...)
+		c.Children = cloneBlocks(b.Children)
+		if b.Items != nil {
+			c.Items = make([][]Block, len(b.Items))
+			for j, item := range b.Items {
+				c.Items[j] = cloneBlocks(item)
+			}
+		}
+		if b.Rows != nil {
+			c.Rows = make([][]TableCell, len(b.Rows))
+			for j, row := range b.Rows {
+				c.Rows[j] = make([]TableCell, len(row))
+				for k, cell := range row {
+					c.Rows[j][k] = TableCell{Header: cell.Header, Inlines: append([]Inline(nil), cell.Inlines...)}
+				}
+			}
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// blockElements lists the elements that terminate an implicit paragraph.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "dd": true, "details": true, "div": true, "dl": true,
+	"dt": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hgroup": true,
+	"hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "summary": true, "table": true,
+	"ul": true,
+}
+
+// skippedElements never contribute content.
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "noscript": true,
+}
+
+// blockBuilder converts an HtmlNode tree into blocks. base is the directory
+// of the chapter document and is used to resolve image sources.
+type blockBuilder struct {
+	base string
+}
+
+// blockSource is the run of sibling nodes a block was built from.
+type blockSource struct {
+	first, last *HtmlNode
+}
+
+// buildBlocks converts the children of container into a sequence of blocks.
+// Consecutive inline children are grouped into implicit paragraphs so that a
+// <div> wrapping several <p> elements yields several paragraphs.
+func (bb *blockBuilder) buildBlocks(container *HtmlNode) []Block {
+	blocks, _ := bb.sourcedBlocks(container)
+	return blocks
+}
+
+// sourcedBlocks is buildBlocks also returning the source of each block.
+func (bb *blockBuilder) sourcedBlocks(container *HtmlNode) ([]Block, []blockSource) {
+	var blocks []Block
+	var sources []blockSource
+	var pending []*HtmlNode
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		var text inlineText
+		for _, n := range pending {
+			bb.appendInlines(&text, n, 0, "")
+		}
+		if block, ok := paragraphBlock(text.inlines, ""); ok {
+			blocks = append(blocks, block)
+			sources = append(sources, blockSource{pending[0], pending[len(pending)-1]})
+		}
+		pending = pending[:0]
+	}
+	for _, child := range container.Children {
+		if child.Type == ElementNode && skippedElements[child.Name] {
+			continue
+		}
+		if child.Type == ElementNode && blockElements[child.Name] {
+			flush()
+			b, src := bb.sourcedBlock(child)
+			blocks = append(blocks, b...)
+			sources = append(sources, src...)
+			continue
+		}
+		pending = append(pending, child)
+	}
+	flush()
+	return blocks, sources
+}
+
+// buildBlock converts a single block-level element.
+func (bb *blockBuilder) buildBlock(n *HtmlNode) []Block {
+	blocks, _ := bb.sourcedBlock(n)
+	return blocks
+}
+
+// sourcedBlock is buildBlock also returning the source of each block.
+// Transparent containers such as <div> yield the blocks of their children.
+func (bb *blockBuilder) sourcedBlock(n *HtmlNode) ([]Block, []blockSource) {
+	if isTransparent(n) {
+		blocks, sources := bb.sourcedBlocks(n)
+		return withFirstID(blocks, n.Attrs["id"]), sources
+	}
+	block, ok := bb.elementBlock(n)
+	if !ok {
+		return nil, nil
+	}
+	return []Block{block}, []blockSource{{n, n}}
+}
+
+// isTransparent reports whether the block-level element n has no block of its
+// own.
+func isTransparent(n *HtmlNode) bool {
+	switch n.Name {
+	case "p", "dt", "dd", "summary", "figcaption":
+		return hasBlockChild(n)
+	case "figure":
+		// Figures without images hold text, such as poems or listings.
+		return !hasImage(n)
+	case "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "blockquote", "table", "pre", "hr":
+		return false
+	}
+	return true
+}
+
+// elementBlock converts a block-level element that is not transparent.
+func (bb *blockBuilder) elementBlock(n *HtmlNode) (Block, bool) {
+	id := n.Attrs["id"]
+	switch n.Name {
+	case "p", "dt", "dd", "summary", "figcaption":
+		return paragraphBlock(bb.inlinesOf(n), id)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		inlines := bb.inlinesOf(n)
+		if inlinesText(inlines) == "" {
+			return Block{}, false
+		}
+		return Block{Kind: BlockHeading, ID: id, Level: int(n.Name[1] - '0'), Inlines: inlines}, true
+	case "ul", "ol":
+		block := Block{Kind: BlockList, ID: id, Ordered: n.Name == "ol"}
+		for _, li := range n.Children {
+			if li.Type != ElementNode {
+				continue
+			}
+			if li.Name == "li" {
+				block.Items = append(block.Items, bb.buildBlocks(li))
+			} else {
+				block.Items = append(block.Items, bb.buildBlock(li))
+			}
+		}
+		return block, len(block.Items) > 0
+	case "blockquote":
+		children := bb.buildBlocks(n)
+		return Block{Kind: BlockQuote, ID: id, Children: children}, len(children) > 0
+	case "table":
+		block := Block{Kind: BlockTable, ID: id}
+		bb.collectRows(n, false, &block)
+		if caption := n.FindNode("caption"); caption != nil {
+			block.Caption = bb.inlinesOf(caption)
+		}
+		return block, len(block.Rows) > 0
+	case "figure":
+		return bb.figureBlock(n, id)
+	case "pre":
+		text := preText(n)
+		return Block{Kind: BlockPreformatted, ID: id, Text: text}, strings.TrimSpace(text) != ""
+	case "hr":
+		return Block{Kind: BlockRule, ID: id}, true
+	}
+	return Block{}, false
+}
+
+// figureBlock collects the images and caption of a figure. Figures with
+// neither yield no block.
+func (bb *blockBuilder) figureBlock(n *HtmlNode, id string) (Block, bool) {
+	block := Block{Kind: BlockFigure, ID: id}
+	var walk func(*HtmlNode)
+	walk = func(node *HtmlNode) {
+		if node.Type != ElementNode {
+			return
+		}
+		if node.Name == "figcaption" {
+			block.Caption = bb.inlinesOf(node)
+			return
+		}
+		if img, ok := bb.imageInline(node); ok {
+			block.Inlines = append(block.Inlines, img)
+		}
+		for _, c := range node.Children {
+			walk(c)
+		}
+	}
+	for _, c := range n.Children {
+		walk(c)
+	}
+	return block, len(block.Inlines) > 0 || len(block.Caption) > 0
+}
+
+func (bb *blockBuilder) collectRows(n *HtmlNode, header bool, block *Block) {
+	for _, child := range n.Children {
+		if child.Type != ElementNode {
+			continue
+		}
+		switch child.Name {
+		case "thead":
+			bb.collectRows(child, true, block)
+		case "tbody", "tfoot":
+			bb.collectRows(child, header, block)
+		case "tr":
+			var row []TableCell
+			for _, cell := range child.Children {
+				if cell.Type != ElementNode || (cell.Name != "td" && cell.Name != "th") {
+					continue
+				}
+				row = append(row, TableCell{
+					Header:  header || cell.Name == "th",
+					Inlines: bb.inlinesOf(cell),
+				})
+			}
+			if len(row) > 0 {
+				block.Rows = append(block.Rows, row)
+			}
+		}
+	}
+}
+
+// inlinesOf flattens the content of n into inline runs.
+func (bb *blockBuilder) inlinesOf(n *HtmlNode) []Inline {
+	var text inlineText
+	for _, c := range n.Children {
+		bb.appendInlines(&text, c, 0, "")
+	}
+	return trimInlines(text.inlines)
+}
+
+func (bb *blockBuilder) appendInlines(text *inlineText, n *HtmlNode, style InlineStyle, href string) {
+	if n.Type == TextNode {
+		text.write(n.Content, style, href)
+		return
+	}
+	if skippedElements[n.Name] {
+		return
+	}
+	if img, ok := bb.imageInline(n); ok {
+		img.Href = href
+		text.image(img)
+		return
+	}
+	switch n.Name {
+	case "b", "strong":
+		style |= StyleBold
+	case "i", "em", "cite", "dfn", "var":
+		style |= StyleItalic
+	case "code", "kbd", "samp", "tt":
+		style |= StyleCode
+	case "a":
+		if h, ok := n.Attrs["href"]; ok {
+			href = strings.TrimSpace(h)
+		}
+	case "br":
+		text.lineBreak(style, href)
+		return
+	}
+	for _, c := range n.Children {
+		bb.appendInlines(text, c, style, href)
+	}
+}
+
+func (bb *blockBuilder) imageInline(n *HtmlNode) (Inline, bool) {
+	if n.Type != ElementNode {
+		return Inline{}, false
+	}
+	var src string
+	switch n.Name {
+	case "img":
+		src = n.Attrs["src"]
+	case "image":
+		src = n.Attrs["href"]
+	default:
+		return Inline{}, false
+	}
+	src = strings.TrimSpace(src)
+	if src == "" {
+		return Inline{}, false
+	}
+	if !isExternalRef(src) {
+		src = cleanPath(resolveRelative(bb.base, src))
+	}
+	return Inline{Text: strings.TrimSpace(n.Attrs["alt"]), Image: src}, true
+}
+
+// paragraphBlock builds a paragraph from inlines. Paragraphs that consist
+// solely of images become figures.
+func paragraphBlock(inlines []Inline, id string) (Block, bool) {
+	inlines = trimInlines(inlines)
+	if inlinesText(inlines) != "" {
+		return Block{Kind: BlockParagraph, ID: id, Inlines: inlines}, true
+	}
+	// Paragraphs holding no more than spaces, such as &nbsp; spacers, are
+	// dropped unless they carry images.
+	var images []Inline
+	for _, in := range inlines {
+		if in.Image != "" {
+			images = append(images, in)
+		}
+	}
+	return Block{Kind: BlockFigure, ID: id, Inlines: images}, len(images) > 0
+}
+
+// inlineText accumulates the inline runs of a paragraph, collapsing white
+// space as CSS does for white-space: normal. A run of white space becomes a
+// single space, also across element boundaries, and is dropped at the start
+// and end of lines; a segment break between two CJK characters is removed.
+type inlineText struct {
+	inlines []Inline
+	space   bool // white space seen since the last character
+	segment bool // the pending white space contains a line feed
+	prev    rune // last character written, 0 at the start of a line
+}
+
+// write appends the text of a text node.
+func (t *inlineText) write(s string, style InlineStyle, href string) {
+	var sb strings.Builder
+	spaceHere := false // the pending white space is part of s
+	for _, r := range s {
+		if isCollapsibleSpace(r) {
+			t.space, spaceHere = true, true
+			t.segment = t.segment || r == '\n' || r == '\r'
+			continue
+		}
+		if t.space && t.prev != 0 && !(t.segment && cjk.Is(t.prev) && cjk.Is(r)) {
+			if !spaceHere && t.lastText() != nil {
+				// The space ended the previous text node, e.g. "see "
+				// before a link, and keeps its formatting.
+				t.lastText().Text += " "
+			} else {
+				sb.WriteByte(' ')
+			}
+		}
+		t.space, t.segment, spaceHere = false, false, false
+		sb.WriteRune(r)
+		t.prev = r
+	}
+	t.appendRun(Inline{Text: sb.String(), Style: style, Href: href})
+}
+
+// lineBreak appends the forced line break of a <br>. White space around it
+// is dropped.
+func (t *inlineText) lineBreak(style InlineStyle, href string) {
+	t.space, t.segment, t.prev = false, false, 0
+	t.appendRun(Inline{Text: "\n", Style: style, Href: href})
+}
+
+// image appends an inline image, which separates words like a character.
+func (t *inlineText) image(img Inline) {
+	if t.space && t.prev != 0 {
+		t.appendRun(Inline{Text: " "})
+	}
+	t.space, t.segment, t.prev = false, false, utf8.RuneError
+	t.inlines = append(t.inlines, img)
+}
+
+// appendRun appends in, merging it with the previous run when they share
+// formatting.
+func (t *inlineText) appendRun(in Inline) {
+	if in.Text == "" {
+		return
+	}
+	if last := t.lastText(); last != nil && last.Style == in.Style && last.Href == in.Href {
+		last.Text += in.Text
+		return
+	}
+	t.inlines = append(t.inlines, in)
+}
+
+// lastText returns the last run when it is text.
+func (t *inlineText) lastText() *Inline {
+	if n := len(t.inlines); n > 0 && t.inlines[n-1].Image == "" {
+		return &t.inlines[n-1]
+	}
+	return nil
+}
+
+// isCollapsibleSpace reports whether r is document white space in the CSS
+// sense. Other Unicode spaces, such as no-break spaces, are kept.
+func isCollapsibleSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+// trimInlines strips leading and trailing white space and line breaks,
+// keeping no-break spaces.
+func trimInlines(inlines []Inline) []Inline {
+	blank := func(in Inline) bool {
+		return in.Image == "" && strings.TrimFunc(in.Text, isCollapsibleSpace) == ""
+	}
+	for len(inlines) > 0 && blank(inlines[0]) {
+		inlines = inlines[1:]
+	}
+	for len(inlines) > 0 && blank(inlines[len(inlines)-1]) {
+		inlines = inlines[:len(inlines)-1]
+	}
+	if len(inlines) == 0 {
+		return nil
+	}
+	if inlines[0].Image == "" {
+		inlines[0].Text = strings.TrimLeftFunc(inlines[0].Text, isCollapsibleSpace)
+	}
+	if last := &inlines[len(inlines)-1]; last.Image == "" {
+		last.Text = strings.TrimRightFunc(last.Text, isCollapsibleSpace)
+	}
+	return inlines
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// preText reconstructs the text of a <pre> element, keeping line breaks.
+func preText(n *HtmlNode) string {
+	var sb strings.Builder
+	var walk func(*HtmlNode)
+	walk = func(node *HtmlNode) {
+		if node.Type == TextNode {
+			sb.WriteString(node.Content)
+			return
+		}
+		if node.Name == "br" {
+			sb.WriteString("\n")
+		}
+		for _, c := range node.Children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+func hasBlockChild(n *HtmlNode) bool {
+	for _, c := range n.Children {
+		if c.Type == ElementNode && blockElements[c.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasImage reports whether an image with a source is nested in n.
+func hasImage(n *HtmlNode) bool {
+	for _, c := range n.Children {
+		if c.Type != ElementNode {
+			continue
+		}
+		if (c.Name == "img" && strings.TrimSpace(c.Attrs["src"]) != "") || (c.Name == "image" && strings.TrimSpace(c.Attrs["href"]) != "") {
+			return true
+		}
+		if hasImage(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// withFirstID carries the id of a transparent container onto its first block
+// so that anchors pointing at the container still resolve.
+func withFirstID(blocks []Block, id string) []Block {
+	if id != "" && len(blocks) > 0 && blocks[0].ID == "" {
+		blocks[0].ID = id
+	}
+	return blocks
+}
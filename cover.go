@@ -0,0 +1,148 @@
+package epub
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+)
+
+// ErrCoverNotFound indicates that none of the cover conventions matched.
+var ErrCoverNotFound = errors.New("cover not found")
+
+// Cover source identifiers reported in CoverImage.Source.
+const (
+	CoverSourceManifest     = "manifest-properties" // EPUB3 properties="cover-image"
+	CoverSourceMeta         = "meta-cover"          // EPUB2 <meta name="cover">
+	CoverSourceGuide        = "guide"               // <guide><reference type="cover">
+	CoverSourceFirstChapter = "first-chapter"       // first image of the first spine item
+)
+
+// CoverImage describes the resolved cover image of a book.
+type CoverImage struct {
+	Href      string `json:"href"`      // container path of the image
+	MediaType string `json:"mediaType"` // manifest media type, or guessed from the extension
+	Source    string `json:"source"`    // which convention produced the match
+}
+
+// Cover resolves the cover image by checking, in order, the EPUB3 manifest
+// cover-image property, the EPUB2 cover meta, the guide cover page and the
+// first image of the first chapter. On success the returned reader streams the
+// image bytes and must be closed by the caller.
+func (b *Book) Cover() (CoverImage, io.ReadCloser, error) {
+	cover, err := b.findCover()
+	if err != nil {
+		return CoverImage{}, nil, err
+	}
+	rc, err := b.OpenResource(cover.Href)
+	if err != nil {
+		return CoverImage{}, nil, err
+	}
+	return cover, rc, nil
+}
+
+func (b *Book) findCover() (CoverImage, error) {
+	if b == nil || b.Opf == nil {
+		return CoverImage{}, ErrCoverNotFound
+	}
+	manifest := b.Opf.Manifest
+	lookup := manifest.HrefLookup(b.opfPath)
+
+	// EPUB3 manifest property.
+	if manifest != nil {
+		for _, item := range manifest.Items {
+			href := strings.TrimSpace(item.Attrs["href"])
+			if href == "" {
+				continue
+			}
+			for _, p := range strings.Fields(item.Attrs["properties"]) {
+				if strings.EqualFold(p, "cover-image") {
+					return b.coverFor(resolveRelative(b.opfDir(), href), CoverSourceManifest), nil
+				}
+			}
+		}
+	}
+
+	// EPUB2 <meta name="cover" content="id">. Some books give the image
+	// path instead of its manifest id.
+	if content := b.Opf.Metadata.metaContent("cover"); content != "" {
+		if href, ok := lookup[content]; ok {
+			return b.coverFor(href, CoverSourceMeta), nil
+		}
+		href := cleanPath(resolveRelative(b.opfDir(), content))
+		if isImageMediaType(b.mediaTypeForPath(href)) {
+			return b.coverFor(href, CoverSourceMeta), nil
+		}
+	}
+
+	// <guide><reference type="cover">, either an image or a page holding one.
+	for _, ref := range b.Opf.guideReferences() {
+		if !strings.EqualFold(strings.TrimSpace(ref.Attrs["type"]), "cover") {
+			continue
+		}
+		href := cleanPath(resolveRelative(b.opfDir(), stripFragment(ref.Attrs["href"])))
+		if isImageMediaType(b.mediaTypeForPath(href)) {
+			return b.coverFor(href, CoverSourceGuide), nil
+		}
+		if img := b.firstImageIn(href); img != "" {
+			return b.coverFor(img, CoverSourceGuide), nil
+		}
+	}
+
+	// First image of the first spine chapter.
+	if chapter, err := b.ChapterByIndex(0); err == nil && len(chapter.Images) > 0 {
+		return b.coverFor(chapter.Images[0], CoverSourceFirstChapter), nil
+	}
+	return CoverImage{}, ErrCoverNotFound
+}
+
+func (b *Book) coverFor(href, source string) CoverImage {
+	href = cleanPath(href)
+	mediaType := b.mediaTypeForPath(href)
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(strings.ToLower(path.Ext(href)))
+	}
+	return CoverImage{Href: href, MediaType: mediaType, Source: source}
+}
+
+// mediaTypeForPath returns the manifest media type declared for the resolved
+// container path.
+func (b *Book) mediaTypeForPath(name string) string {
+	if b == nil || b.Opf == nil || b.Opf.Manifest == nil {
+		return ""
+	}
+	for _, item := range b.Opf.Manifest.Items {
+		href := strings.TrimSpace(item.Attrs["href"])
+		if href != "" && cleanPath(resolveRelative(b.opfDir(), href)) == name {
+			return strings.TrimSpace(item.Attrs["media-type"])
+		}
+	}
+	return ""
+}
+
+// firstImageIn parses the XHTML page at href and returns its first image.
+func (b *Book) firstImageIn(href string) string {
+	rc, err := b.OpenResource(href)
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	chapter, err := ParseChapterFromReader("", href, rc)
+	if err != nil || len(chapter.Images) == 0 {
+		return ""
+	}
+	return chapter.Images[0]
+}
+
+func isImageMediaType(mediaType string) bool {
+	return strings.HasPrefix(strings.ToLower(mediaType), "image/")
+}
+
+// stripFragment removes a trailing #fragment from href.
+func stripFragment(href string) string {
+	if i := strings.IndexByte(href, '#'); i >= 0 {
+		return href[:i]
+	}
+	return href
+}
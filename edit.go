@@ -0,0 +1,474 @@
+package epub
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNoPackage is returned by editing methods on books without an OPF.
+var errNoPackage = errors.New("book has no package document")
+
+// dcOrder is the order in which Dublin Core elements are written when the
+// metadata section is regenerated.
+var dcOrder = []string{
+	"identifier", "title", "language", "creator", "contributor", "publisher",
+	"date", "description", "subject", "rights", "type", "format", "source",
+	"relation", "coverage",
+}
+
+// SetTitle replaces the value of the main title: the title refined with the
+// title-type main, or the first title when none is. Refinements attached to
+// the title are kept. A title is added if none exists.
+func (b *Book) SetTitle(title string) error {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return err
+	}
+	entries := md.Data[md.nsFor("title")]["title"]
+	if len(entries) == 0 {
+		md.add(nsDC, "title", MetaEntry{Value: title, Attrs: map[string]string{}})
+		return nil
+	}
+	refinements := md.refinements()
+	main := slices.IndexFunc(entries, func(e MetaEntry) bool { return titleType(e, refinements) == "main" })
+	entries[max(main, 0)].Value = title
+	md.dirty = true
+	return nil
+}
+
+// SetMetadataValues replaces every value of the Dublin Core element key with
+// values. Refinements of the removed elements are dropped as well.
+func (b *Book) SetMetadataValues(key string, values ...string) error {
+	if err := b.RemoveMetadata(key); err != nil {
+		return err
+	}
+	md := b.Opf.Metadata
+	for _, v := range values {
+		md.add(nsDC, strings.ToLower(strings.TrimSpace(key)), MetaEntry{Value: v, Attrs: map[string]string{}})
+	}
+	return nil
+}
+
+// RemoveMetadata removes every value of the Dublin Core element key together
+// with the EPUB 3 <meta refines> entries that refine them.
+func (b *Book) RemoveMetadata(key string) error {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return err
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	if !in(dublinCoreElements, key) {
+		return fmt.Errorf("unknown dublin core element: %s", key)
+	}
+	for ns, tags := range md.Data {
+		if _, ok := tags[key]; !ok {
+			continue
+		}
+		for _, entry := range tags[key] {
+			md.removeRefinements(entry.Attrs["id"])
+		}
+		delete(tags, key)
+		md.dirty = true
+		if len(tags) == 0 {
+			delete(md.Data, ns)
+		}
+	}
+	return nil
+}
+
+// AddCreator appends a dc:creator. Role is a MARC relator code such as "aut"
+// or "edt" and fileAs the sort form of the name; both are optional. EPUB 3
+// books record them as <meta refines> entries, EPUB 2 books as opf:role and
+// opf:file-as attributes.
+func (b *Book) AddCreator(name, role, fileAs string) error {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return err
+	}
+	entry := MetaEntry{Value: name, Attrs: map[string]string{}}
+	if !b.Opf.isEPUB3() {
+		if role != "" {
+			entry.setAttr(nsOPF, "role", role)
+		}
+		if fileAs != "" {
+			entry.setAttr(nsOPF, "file-as", fileAs)
+		}
+		md.add(nsDC, "creator", entry)
+		return nil
+	}
+	id := md.uniqueID("creator", true)
+	entry.Attrs["id"] = id
+	md.add(nsDC, "creator", entry)
+	if role != "" {
+		md.add(md.defaultNS, "meta", MetaEntry{Value: role, Attrs: map[string]string{
+			"refines": "#" + id, "property": "role", "scheme": "marc:relators",
+		}})
+	}
+	if fileAs != "" {
+		md.add(md.defaultNS, "meta", MetaEntry{Value: fileAs, Attrs: map[string]string{
+			"refines": "#" + id, "property": "file-as",
+		}})
+	}
+	return nil
+}
+
+// SetSeries records the series of the book and the book's position in it.
+// EPUB 3 books get a belongs-to-collection meta refined with collection-type
+// series and, for a non-zero index, group-position; EPUB 2 books get the
+// calibre:series and calibre:series_index meta tags. Existing series entries
+// of either form are replaced. An empty name removes the series.
+func (b *Book) SetSeries(name string, index float64) error {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return err
+	}
+	for _, c := range b.Collections() {
+		if c.Type == "series" || c.Type == "" {
+			md.removeRefinements(c.ID)
+			md.removeMeta(func(e MetaEntry) bool {
+				return e.Attrs["property"] == "belongs-to-collection" && e.Attrs["refines"] == "" && e.Attrs["id"] == c.ID &&
+					collapseSpace(e.Value) == c.Name
+			})
+		}
+	}
+	md.removeMeta(func(e MetaEntry) bool {
+		return e.Attrs["name"] == "calibre:series" || e.Attrs["name"] == "calibre:series_index"
+	})
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	position := strconv.FormatFloat(index, 'f', -1, 64)
+	if !b.Opf.isEPUB3() {
+		md.add(md.defaultNS, "meta", MetaEntry{Attrs: map[string]string{"name": "calibre:series", "content": name}})
+		if index != 0 {
+			md.add(md.defaultNS, "meta", MetaEntry{Attrs: map[string]string{"name": "calibre:series_index", "content": position}})
+		}
+		return nil
+	}
+	id := md.uniqueID("series", true)
+	md.add(md.defaultNS, "meta", MetaEntry{Value: name, Attrs: map[string]string{"property": "belongs-to-collection", "id": id}})
+	md.add(md.defaultNS, "meta", MetaEntry{Value: "series", Attrs: map[string]string{"refines": "#" + id, "property": "collection-type"}})
+	if index != 0 {
+		md.add(md.defaultNS, "meta", MetaEntry{Value: position, Attrs: map[string]string{"refines": "#" + id, "property": "group-position"}})
+	}
+	return nil
+}
+
+// SetIdentifier sets the value of the identifier referenced by the package
+// unique-identifier attribute, creating the identifier when necessary.
+func (b *Book) SetIdentifier(value string) error {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return err
+	}
+	uid := b.Opf.uniqueIdentifier()
+	entries := md.Data[md.nsFor("identifier")]["identifier"]
+	for i := range entries {
+		if uid != "" && entries[i].Attrs["id"] == uid {
+			entries[i].Value = value
+			md.dirty = true
+			return nil
+		}
+	}
+	if uid == "" {
+		uid = md.uniqueID("pub-id", false)
+		b.Opf.setPackageAttr("unique-identifier", uid)
+	}
+	md.add(nsDC, "identifier", MetaEntry{Value: value, Attrs: map[string]string{"id": uid}})
+	return nil
+}
+
+// SetMeta sets the content of the EPUB 2 style <meta name="name" content="...">
+// entry, replacing existing entries with the same name.
+func (b *Book) SetMeta(name, content string) error {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return err
+	}
+	md.removeMeta(func(e MetaEntry) bool { return e.Attrs["name"] == name })
+	md.add(md.defaultNS, "meta", MetaEntry{Attrs: map[string]string{"name": name, "content": content}})
+	return nil
+}
+
+// SetMetaProperty sets the value of the EPUB 3 <meta property="property">
+// entry that does not refine another element, replacing existing entries.
+func (b *Book) SetMetaProperty(property, value string) error {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return err
+	}
+	md.removeMeta(func(e MetaEntry) bool {
+		return e.Attrs["property"] == property && e.Attrs["refines"] == ""
+	})
+	md.add(md.defaultNS, "meta", MetaEntry{Value: value, Attrs: map[string]string{"property": property}})
+	return nil
+}
+
+// RemoveMeta removes every <meta> entry whose name or property equals name
+// and returns how many entries were removed.
+func (b *Book) RemoveMeta(name string) (int, error) {
+	md, err := b.editableMetadata()
+	if err != nil {
+		return 0, err
+	}
+	return md.removeMeta(func(e MetaEntry) bool {
+		return e.Attrs["name"] == name || e.Attrs["property"] == name
+	}), nil
+}
+
+func (b *Book) editableMetadata() (*Metadata, error) {
+	if b == nil || b.Opf == nil || b.Opf.XmlNode == nil {
+		return nil, errNoPackage
+	}
+	if b.Opf.Metadata == nil {
+		b.Opf.Metadata = &Metadata{Data: make(map[string]map[string][]MetaEntry), defaultNS: nsOPF}
+	}
+	if b.Opf.Metadata.Data == nil {
+		b.Opf.Metadata.Data = make(map[string]map[string][]MetaEntry)
+	}
+	return b.Opf.Metadata, nil
+}
+
+// add 追加元数据条目 / add appends an entry, remembering the first appearance of its tag, and
+// marks the metadata as edited.
+func (md *Metadata) add(ns, tag string, entry MetaEntry) {
+	if _, ok := md.Data[ns]; !ok {
+		md.Data[ns] = make(map[string][]MetaEntry)
+	}
+	if _, ok := md.Data[ns][tag]; !ok {
+		md.order = append(md.order, metaKey{ns: ns, tag: tag})
+	}
+	md.Data[ns][tag] = append(md.Data[ns][tag], entry)
+	md.dirty = true
+}
+
+// nsFor returns the namespace holding tag, defaulting to Dublin Core.
+func (md *Metadata) nsFor(tag string) string {
+	for ns, tags := range md.Data {
+		if _, ok := tags[tag]; ok {
+			return ns
+		}
+	}
+	return nsDC
+}
+
+func (md *Metadata) removeMeta(match func(MetaEntry) bool) int {
+	removed := 0
+	for _, tags := range md.Data {
+		entries := tags["meta"]
+		if entries == nil {
+			continue
+		}
+		kept := entries[:0]
+		for _, e := range entries {
+			if match(e) {
+				removed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		tags["meta"] = kept
+	}
+	if removed > 0 {
+		md.dirty = true
+	}
+	return removed
+}
+
+func (md *Metadata) removeRefinements(id string) {
+	if id == "" {
+		return
+	}
+	md.removeMeta(func(e MetaEntry) bool { return e.Attrs["refines"] == "#"+id })
+}
+
+// uniqueID returns an id that is not yet used by any metadata entry. It is
+// prefix itself if available, or prefix followed by a number; numbered forces
+// the numeric suffix.
+func (md *Metadata) uniqueID(prefix string, numbered bool) string {
+	used := make(map[string]bool)
+	for _, tags := range md.Data {
+		for _, entries := range tags {
+			for _, e := range entries {
+				used[e.Attrs["id"]] = true
+			}
+		}
+	}
+	id := prefix
+	if numbered {
+		id = prefix + "1"
+	}
+	for i := 2; used[id]; i++ {
+		id = prefix + strconv.Itoa(i)
+	}
+	return id
+}
+
+func (e *MetaEntry) setAttr(space, name, value string) {
+	if e.Attrs == nil {
+		e.Attrs = make(map[string]string)
+	}
+	e.Attrs[name] = value
+	if space != "" {
+		if e.spaces == nil {
+			e.spaces = make(map[string]string)
+		}
+		e.spaces[name] = space
+	}
+}
+
+// isEPUB3 reports whether the package declares version 3.x.
+func (opf *Opf) isEPUB3() bool {
+	version, _ := opf.XmlNode.Attr("version")
+	return strings.HasPrefix(strings.TrimSpace(version), "3")
+}
+
+// uniqueIdentifier returns the id referenced by the package unique-identifier
+// attribute.
+func (opf *Opf) uniqueIdentifier() string {
+	uid, _ := opf.XmlNode.Attr("unique-identifier")
+	return strings.TrimSpace(uid)
+}
+
+func (opf *Opf) setPackageAttr(name, value string) {
+	for i := range opf.XmlNode.Attrs {
+		if opf.XmlNode.Attrs[i].Name.Local == name {
+			opf.XmlNode.Attrs[i].Value = value
+			return
+		}
+	}
+	opf.XmlNode.Attrs = append(opf.XmlNode.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
+
+// syncMetadata 将 Metadata 写回 XmlNode / syncMetadata rebuilds the <metadata> element of the package
+// document from opf.Metadata so that edits are serialised. Parsed elements
+// keep their position, and their original form unless edited. Added elements
+// follow the last element with the same tag, or go at the end in the order
+// their tags first appeared.
+func (opf *Opf) syncMetadata() {
+	if opf == nil || opf.Metadata == nil {
+		return
+	}
+	mdNode := opf.XmlNode.FindNode("metadata")
+	if mdNode == nil {
+		return
+	}
+	md := opf.Metadata
+
+	keys := make([]metaKey, 0, len(md.order))
+	seen := make(map[metaKey]bool)
+	for _, k := range md.order {
+		if _, ok := md.Data[k.ns][k.tag]; ok && !seen[k] {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	// Entries added directly to Data: Dublin Core first, then the rest.
+	var extra []metaKey
+	for ns, tags := range md.Data {
+		for tag := range tags {
+			if k := (metaKey{ns: ns, tag: tag}); !seen[k] {
+				extra = append(extra, k)
+			}
+		}
+	}
+	rank := func(tag string) int {
+		for i, t := range dcOrder {
+			if t == tag {
+				return i
+			}
+		}
+		return len(dcOrder)
+	}
+	sort.Slice(extra, func(i, j int) bool {
+		if ri, rj := rank(extra[i].tag), rank(extra[j].tag); ri != rj {
+			return ri < rj
+		}
+		return extra[i].ns+extra[i].tag < extra[j].ns+extra[j].tag
+	})
+	keys = append(keys, extra...)
+
+	// slot is an entry of Data, identified by its key and index.
+	type slot struct {
+		key metaKey
+		i   int
+	}
+	parsed := make(map[*XmlNode]slot)
+	var added []slot
+	for _, k := range keys {
+		for i, e := range md.Data[k.ns][k.tag] {
+			if _, dup := parsed[e.src]; e.src != nil && !dup {
+				parsed[e.src] = slot{k, i}
+			} else {
+				added = append(added, slot{k, i})
+			}
+		}
+	}
+	var order []slot
+	for i := range mdNode.XmlNodes {
+		if s, ok := parsed[&mdNode.XmlNodes[i]]; ok {
+			order = append(order, s)
+		}
+	}
+	for _, s := range added {
+		at := len(order)
+		for i := len(order) - 1; i >= 0; i-- {
+			if order[i].key == s.key {
+				at = i + 1
+				break
+			}
+		}
+		order = slices.Insert(order, at, s)
+	}
+
+	children := make([]XmlNode, len(order))
+	for i, s := range order {
+		e := md.Data[s.key.ns][s.key.tag][s.i]
+		if p, ok := parsed[e.src]; ok && p == s && e.equal(metaEntryFor(e.src)) {
+			children[i] = *e.src
+		} else {
+			children[i] = e.node(s.key.ns, s.key.tag)
+		}
+	}
+	mdNode.XmlNodes = children
+	mdNode.Content = ""
+	for i, s := range order {
+		md.Data[s.key.ns][s.key.tag][s.i].src = &mdNode.XmlNodes[i]
+	}
+}
+
+// equal reports whether e and o have the same value and attributes.
+func (e MetaEntry) equal(o MetaEntry) bool {
+	return e.Value == o.Value && maps.Equal(e.Attrs, o.Attrs) && maps.Equal(e.spaces, o.spaces)
+}
+
+// node converts the entry back into an XML element.
+func (e MetaEntry) node(ns, tag string) XmlNode {
+	node := XmlNode{XMLName: xml.Name{Space: ns, Local: tag}, Content: e.Value}
+	names := make([]string, 0, len(e.Attrs))
+	for name := range e.Attrs {
+		names = append(names, name)
+	}
+	// Keep the output stable: id first, then alphabetical.
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == "id") != (names[j] == "id") {
+			return names[i] == "id"
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		space := e.spaces[name]
+		if space == "" && name == "lang" {
+			space = nsXML
+		}
+		node.Attrs = append(node.Attrs, xml.Attr{Name: xml.Name{Space: space, Local: name}, Value: e.Attrs[name]})
+	}
+	return node
+}
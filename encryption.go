@@ -0,0 +1,214 @@
+package epub
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Algorithm URIs found in META-INF/encryption.xml.
+const (
+	// AlgorithmIDPFFont is the IDPF font obfuscation: the first 1040 bytes
+	// are XORed with the SHA-1 of the unique identifier.
+	AlgorithmIDPFFont = "http://www.idpf.org/2008/embedding"
+	// AlgorithmAdobeFont is the Adobe font obfuscation: the first 1024 bytes
+	// are XORed with the 16 bytes of the urn:uuid identifier.
+	AlgorithmAdobeFont = "http://ns.adobe.com/pdf/enc#RC"
+)
+
+const encryptionPath = "META-INF/encryption.xml"
+
+// EncryptedResource 对应 encryption.xml 中的 EncryptedData / EncryptedResource is an EncryptedData entry of
+// META-INF/encryption.xml.
+type EncryptedResource struct {
+	Path      string `json:"path"`              // container path of the resource, percent-decoded
+	Algorithm string `json:"algorithm"`         // EncryptionMethod Algorithm URI
+	KeyName   string `json:"keyName,omitempty"` // ds:KeyInfo key name or retrieval method URI
+	// Compression is the EncryptionProperties compression method: 0 when the
+	// resource is stored, 8 when it was deflated before encryption.
+	Compression    int   `json:"compression,omitempty"`
+	OriginalLength int64 `json:"originalLength,omitempty"` // size before compression, 0 when unknown
+}
+
+// Obfuscated reports whether the resource uses one of the font obfuscation
+// algorithms, which are undone transparently by Book.OpenResource.
+func (r EncryptedResource) Obfuscated() bool {
+	return r.Algorithm == AlgorithmIDPFFont || r.Algorithm == AlgorithmAdobeFont
+}
+
+// ParseEncryption parses the META-INF/encryption.xml document.
+func ParseEncryption(content []byte) ([]EncryptedResource, error) {
+	root, err := ParseXML(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse encryption: %w", err)
+	}
+	var resources []EncryptedResource
+	for _, data := range root.FindNodes("EncryptedData") {
+		var res EncryptedResource
+		if method := data.FindNode("EncryptionMethod"); method != nil {
+			res.Algorithm, _ = method.Attr("Algorithm")
+			res.Algorithm = strings.TrimSpace(res.Algorithm)
+		}
+		if ref := data.FindNode("CipherReference"); ref != nil {
+			uri, _ := ref.Attr("URI")
+			res.Path = archiveName("", uri)
+		}
+		if info := data.FindNode("KeyInfo"); info != nil {
+			if name := info.FindNode("KeyName"); name != nil {
+				res.KeyName = strings.TrimSpace(name.NodeText())
+			} else if retrieval := info.FindNode("RetrievalMethod"); retrieval != nil {
+				res.KeyName, _ = retrieval.Attr("URI")
+			}
+		}
+		if compression := data.FindNode("Compression"); compression != nil {
+			method, _ := compression.Attr("Method")
+			length, _ := compression.Attr("OriginalLength")
+			res.Compression, _ = strconv.Atoi(strings.TrimSpace(method))
+			res.OriginalLength, _ = strconv.ParseInt(strings.TrimSpace(length), 10, 64)
+		}
+		if res.Path == "" || res.Path == "." {
+			continue
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+// EncryptedResources returns the resources listed in META-INF/encryption.xml.
+func (b *Book) EncryptedResources() []EncryptedResource {
+	if b == nil {
+		return nil
+	}
+	return append([]EncryptedResource(nil), b.encryption...)
+}
+
+// encryptedResource returns the encryption entry of the resource at name.
+func (b *Book) encryptedResource(name string) (EncryptedResource, bool) {
+	for _, res := range b.encryption {
+		if res.Path == name {
+			return res, true
+		}
+	}
+	return EncryptedResource{}, false
+}
+
+// fontKeys holds the font obfuscation keys derived from the identifiers of a
+// package.
+type fontKeys struct {
+	idpf  []byte
+	adobe []byte
+}
+
+// fontKeys derives the obfuscation keys from the current metadata.
+func (b *Book) fontKeys() fontKeys {
+	var keys fontKeys
+	uid, err := b.UniqueIdentifier()
+	if err == nil {
+		keys.idpf = idpfFontKey(uid)
+	}
+	candidates := []string{uid}
+	if b.Opf != nil {
+		candidates = append(candidates, b.Opf.Metadata.Get("identifier")...)
+	}
+	for _, id := range candidates {
+		if key := adobeFontKey(id); key != nil {
+			keys.adobe = key
+			break
+		}
+	}
+	return keys
+}
+
+// key returns the key and the number of obfuscated bytes for algorithm.
+func (k fontKeys) key(algorithm string) ([]byte, int) {
+	switch algorithm {
+	case AlgorithmIDPFFont:
+		return k.idpf, 1040
+	case AlgorithmAdobeFont:
+		return k.adobe, 1024
+	}
+	return nil, 0
+}
+
+// idpfFontKey is the SHA-1 of the unique identifier without white space.
+func idpfFontKey(uid string) []byte {
+	uid = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, uid)
+	sum := sha1.Sum([]byte(uid))
+	return sum[:]
+}
+
+// adobeFontKey returns the 16 bytes of a urn:uuid identifier, or nil.
+func adobeFontKey(id string) []byte {
+	id = strings.TrimSpace(id)
+	if len(id) >= 9 && strings.EqualFold(id[:9], "urn:uuid:") {
+		id = id[9:]
+	}
+	key, err := hex.DecodeString(strings.ReplaceAll(id, "-", ""))
+	if err != nil || len(key) != 16 {
+		return nil
+	}
+	return key
+}
+
+// obfuscate XORs the first limit bytes of data with key. The operation is its
+// own inverse.
+func obfuscate(data, key []byte, limit int) {
+	for i := 0; i < limit && i < len(data); i++ {
+		data[i] ^= key[i%len(key)]
+	}
+}
+
+// obfuscatedReader undoes font obfuscation while streaming a resource.
+type obfuscatedReader struct {
+	io.ReadCloser
+	key   []byte
+	limit int
+	pos   int
+}
+
+func (r *obfuscatedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.pos < r.limit {
+		for i := 0; i < n && r.pos < r.limit; i++ {
+			p[i] ^= r.key[r.pos%len(r.key)]
+			r.pos++
+		}
+	}
+	return n, err
+}
+
+// needsRekey reports whether the obfuscated resource at name must be
+// obfuscated again with keys, the keys of the current identifiers, because an
+// edit changed them since the book was read.
+func (b *Book) needsRekey(name string, keys fontKeys) bool {
+	res, ok := b.encryptedResource(name)
+	if !ok || !res.Obfuscated() {
+		return false
+	}
+	old, _ := b.keys.key(res.Algorithm)
+	current, _ := keys.key(res.Algorithm)
+	return old != nil && !bytes.Equal(old, current)
+}
+
+// rekey deobfuscates data, the stored bytes of the resource at name, with the
+// key the book was read with and obfuscates it again with keys.
+func (b *Book) rekey(name string, data []byte, keys fontKeys) error {
+	res, _ := b.encryptedResource(name)
+	old, limit := b.keys.key(res.Algorithm)
+	current, _ := keys.key(res.Algorithm)
+	if current == nil {
+		return fmt.Errorf("obfuscate %s: package has no identifier usable as %s key", name, res.Algorithm)
+	}
+	obfuscate(data, old, limit)
+	obfuscate(data, current, limit)
+	return nil
+}
@@ -1,6 +1,8 @@
 package epub
 
 import (
+	"encoding/xml"
+	"errors"
 	"io"
 	"strings"
 
@@ -50,14 +52,141 @@ type HtmlNode struct {
 }
 
 // ParseHTML 解析 HTML/XHTML，返回 HtmlNode 树
+// 声明的字符编码（BOM、XML 声明或 <meta charset>）会被转换为 UTF-8
+// 元素嵌套超过 DefaultMaxDepth 时返回 *LimitError
 func ParseHTML(r io.Reader) (*HtmlNode, error) {
+	return parseHTML(r, DefaultMaxDepth)
+}
+
+// parseHTML is ParseHTML with the nesting limit maxDepth, none when <= 0.
+func parseHTML(r io.Reader, maxDepth int) (*HtmlNode, error) {
+	r, err := newHTMLReader(r)
+	if err != nil {
+		return nil, err
+	}
 	root, err := html.Parse(r)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkHTMLDepth(root, maxDepth); err != nil {
+		return nil, err
+	}
 	return convertHTMLNode(root), nil
 }
 
+// ParseDocument 解析 XHTML 内容文档并保留原始 DOM / ParseDocument parses an XHTML content document into a HtmlNode tree
+// that mirrors its DOM: whitespace-only text nodes are kept verbatim and no
+// implied elements (tbody, head, ...) are synthesised. Adjacent character data
+// separated by comments or processing instructions is merged into a single
+// text node. Positions such as EPUB CFI steps must be computed on this tree
+// rather than on the one returned by ParseHTML. Documents nesting deeper than
+// DefaultMaxDepth are rejected with a *LimitError; Book.OpenDocument applies
+// the limits of the book instead.
+func ParseDocument(r io.Reader) (*HtmlNode, error) {
+	return parseDocumentTree(r, DefaultMaxDepth)
+}
+
+// OpenDocument reads the content document at name and parses it as
+// ParseDocument does, rejecting documents nesting deeper than the
+// ReadOptions.Limits of the book.
+func (b *Book) OpenDocument(name string) (root *HtmlNode, err error) {
+	rc, err := b.OpenResource(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, rc.Close())
+	}()
+	return parseDocumentTree(rc, b.limits.MaxDepth)
+}
+
+// parseDocumentTree is ParseDocument with a nesting limit; a limit of zero or
+// less disables it.
+func parseDocumentTree(r io.Reader, maxDepth int) (*HtmlNode, error) {
+	r, err := newHTMLReader(r)
+	if err != nil {
+		return nil, err
+	}
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = utf8Passthrough
+
+	var root *HtmlNode
+	var stack []*HtmlNode
+	for {
+		tok, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &HtmlNode{
+				Type:     ElementNode,
+				Name:     t.Name.Local,
+				Attrs:    make(map[string]string, len(t.Attr)),
+				Children: []*HtmlNode{},
+			}
+			for _, attr := range t.Attr {
+				node.Attrs[documentAttrName(attr.Name)] = attr.Value
+			}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, errors.New("document has more than one root element")
+				}
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			}
+			stack = append(stack, node)
+			if maxDepth > 0 && len(stack) > maxDepth {
+				return nil, &LimitError{Limit: "depth", Value: int64(len(stack)), Max: int64(maxDepth)}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			parent := stack[len(stack)-1]
+			if n := len(parent.Children); n > 0 && parent.Children[n-1].Type == TextNode {
+				parent.Children[n-1].Content += string(t)
+				continue
+			}
+			parent.Children = append(parent.Children, &HtmlNode{Type: TextNode, Content: string(t)})
+		}
+	}
+	if root == nil {
+		return nil, errors.New("document has no root element")
+	}
+	return root, nil
+}
+
+// documentAttrNamespaces 将常见命名空间映射回前缀 / documentAttrNamespaces maps well-known attribute namespaces back to
+// the prefixes ParseHTML reports, e.g. epub:type.
+var documentAttrNamespaces = map[string]string{
+	"http://www.idpf.org/2007/ops":         "epub",
+	"http://www.w3.org/XML/1998/namespace": "xml",
+	"http://www.w3.org/1999/xlink":         "xlink",
+	"xmlns":                                "xmlns",
+	"xml":                                  "xml",
+	"epub":                                 "epub",
+}
+
+func documentAttrName(name xml.Name) string {
+	if prefix, ok := documentAttrNamespaces[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	return name.Local
+}
+
 // convertHTMLNode 将 html.Node 转为 HtmlNode
 func convertHTMLNode(n *html.Node) *HtmlNode {
 	switch n.Type {
@@ -87,16 +216,15 @@ func convertHTMLNode(n *html.Node) *HtmlNode {
 		return node
 
 	case html.TextNode:
-		text := strings.TrimSpace(n.Data)
-		if text == "" {
-			return nil
-		}
-		return &HtmlNode{Type: TextNode, Content: text}
+		// White space is kept verbatim; whether it separates words depends
+		// on the surrounding elements and is decided when text is extracted.
+		return &HtmlNode{Type: TextNode, Content: n.Data}
 	}
 	return nil
 }
 
 // NodeText 递归提取所有文本（去除标签与多余空白）
+// 块级元素与 <br> 之间以空格分隔，连续空白折叠为一个空格
 func (hn *HtmlNode) NodeText() string {
 	if hn == nil {
 		return ""
@@ -106,15 +234,24 @@ func (hn *HtmlNode) NodeText() string {
 	for len(stack) > 0 {
 		node := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
+		if node == nil {
+			// Closing a block element.
+			sb.WriteString(" ")
+			continue
+		}
 		if node.Type == TextNode {
 			sb.WriteString(node.Content)
+			continue
+		}
+		if blockElements[node.Name] || node.Name == "br" {
 			sb.WriteString(" ")
+			stack = append(stack, nil)
 		}
 		for i := len(node.Children) - 1; i >= 0; i-- {
 			stack = append(stack, node.Children[i])
 		}
 	}
-	return strings.TrimSpace(sb.String())
+	return collapseSpace(sb.String())
 }
 
 func (hn *HtmlNode) FindNode(name string) *HtmlNode {
@@ -0,0 +1,523 @@
+// Package index maintains a persistent full-text index over a library of EPUB
+// books.
+//
+// Every chapter of an added book becomes a document of the index. Books are
+// keyed by their OPF unique identifier, so adding a book again replaces the
+// previous version. Postings are kept in immutable segment files on disk;
+// Flush writes buffered additions as a new segment, and Compact merges all
+// segments and drops the postings of removed books. Queries are ranked with
+// BM25 across books and report chapter-level locations.
+package index
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	epub "github.com/ArcadiaLin/go-epub"
+)
+
+const (
+	manifestName  = "manifest.gob"
+	formatVersion = 1
+
+	defaultK1          = 1.2
+	defaultB           = 0.75
+	defaultMaxSegments = 16
+)
+
+var (
+	// ErrNoIdentifier indicates that a book cannot be indexed because its
+	// package declares no identifier.
+	ErrNoIdentifier = errors.New("book has no unique identifier")
+	// ErrTokenizerMismatch indicates that an index is reopened with a
+	// different tokenizer than the one it was built with.
+	ErrTokenizerMismatch = errors.New("index tokenizer mismatch")
+	// ErrClosed indicates that the index has been closed.
+	ErrClosed = errors.New("index is closed")
+)
+
+// Options configures an index. The zero value selects MixedTokenizer and the
+// usual BM25 parameters k1 = 1.2 and b = 0.75.
+type Options struct {
+	Tokenizer Tokenizer
+	K1        float64
+	// B is the BM25 length normalisation. Zero selects 0.75; a negative
+	// value selects b = 0, which ignores document length.
+	B float64
+	// MaxSegments triggers Compact from Flush once more segment files exist.
+	// Zero selects 16; a negative value disables automatic compaction.
+	MaxSegments int
+}
+
+// Book describes an indexed book.
+type Book struct {
+	Key      string `json:"key"` // OPF unique identifier
+	Title    string `json:"title,omitempty"`
+	Source   string `json:"source,omitempty"` // caller supplied location, e.g. the file path
+	Chapters int    `json:"chapters"`
+}
+
+// Hit is a chapter matching a query.
+type Hit struct {
+	Book         Book    `json:"book"`
+	ChapterIndex int     `json:"chapterIndex"`
+	ChapterID    string  `json:"chapterId"`
+	Path         string  `json:"path"`
+	Score        float64 `json:"score"`
+}
+
+// manifest is the persistent state besides the segment files.
+type manifest struct {
+	Version     int
+	Tokenizer   string
+	NextDoc     uint32
+	NextSegment int
+	Segments    []string
+	Books       map[string]*bookRecord
+	Docs        map[uint32]docRecord
+	TotalLength int64 // sum of the lengths of all live documents
+}
+
+type bookRecord struct {
+	Book
+	Docs []uint32
+}
+
+type docRecord struct {
+	Book         string
+	ChapterIndex int
+	ChapterID    string
+	Path         string
+	Length       int
+}
+
+// Index is a persistent inverted index stored in a directory. It is safe for
+// concurrent use.
+type Index struct {
+	dir  string
+	opts Options
+
+	mu       sync.RWMutex
+	state    manifest
+	segments []*segment
+	pending  map[string][]posting // postings added since the last Flush
+	dirty    bool
+	closed   bool
+}
+
+// Open opens the index stored in dir, creating the directory and an empty
+// index when necessary.
+func Open(dir string, opts Options) (*Index, error) {
+	if opts.Tokenizer == nil {
+		opts.Tokenizer = MixedTokenizer{}
+	}
+	if opts.K1 <= 0 {
+		opts.K1 = defaultK1
+	}
+	switch {
+	case opts.B == 0:
+		opts.B = defaultB
+	case opts.B < 0:
+		opts.B = 0
+	}
+	if opts.MaxSegments == 0 {
+		opts.MaxSegments = defaultMaxSegments
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ix := &Index{dir: dir, opts: opts, pending: make(map[string][]posting)}
+	if err := ix.load(); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *Index) load() error {
+	f, err := os.Open(filepath.Join(ix.dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		ix.state = manifest{
+			Version:   formatVersion,
+			Tokenizer: ix.opts.Tokenizer.Name(),
+			Books:     make(map[string]*bookRecord),
+			Docs:      make(map[uint32]docRecord),
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = gob.NewDecoder(f).Decode(&ix.state)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCorrupt, manifestName, err)
+	}
+	if ix.state.Version != formatVersion {
+		return fmt.Errorf("%w: unsupported format version %d", ErrCorrupt, ix.state.Version)
+	}
+	if ix.state.Tokenizer != ix.opts.Tokenizer.Name() {
+		return fmt.Errorf("%w: index uses %q, got %q", ErrTokenizerMismatch, ix.state.Tokenizer, ix.opts.Tokenizer.Name())
+	}
+	if ix.state.Books == nil {
+		ix.state.Books = make(map[string]*bookRecord)
+	}
+	if ix.state.Docs == nil {
+		ix.state.Docs = make(map[uint32]docRecord)
+	}
+	for _, name := range ix.state.Segments {
+		seg, err := openSegment(filepath.Join(ix.dir, name), name)
+		if err != nil {
+			return errors.Join(err, ix.closeSegments())
+		}
+		ix.segments = append(ix.segments, seg)
+	}
+	return nil
+}
+
+// AddFile reads the EPUB at path and adds it with path as its source.
+func (ix *Index) AddFile(path string) error {
+	book, err := epub.ReadBook(path, epub.ReadOptions{LazyChapters: true})
+	if err != nil {
+		return err
+	}
+	return errors.Join(ix.Add(book, path), book.Close())
+}
+
+// Add indexes every chapter of book under its unique identifier, replacing a
+// previously added book with the same identifier. Additions become
+// searchable immediately and are persisted by Flush or Close.
+func (ix *Index) Add(book *epub.Book, source string) error {
+	key, err := book.UniqueIdentifier()
+	if err != nil || key == "" {
+		return ErrNoIdentifier
+	}
+	title, _ := book.Title()
+
+	// Tokenise outside the lock; it dominates the cost of adding a book.
+	type chapterTerms struct {
+		id, path string
+		freqs    map[string]uint32
+		length   int
+	}
+	chapters := make([]chapterTerms, 0, book.ChapterCount())
+	for i := 0; i < book.ChapterCount(); i++ {
+		chapter, err := book.ChapterByIndex(i)
+		if err != nil {
+			return err
+		}
+		terms := ix.opts.Tokenizer.Tokenize(chapter.Text())
+		freqs := make(map[string]uint32)
+		for _, term := range terms {
+			freqs[term]++
+		}
+		chapters = append(chapters, chapterTerms{id: chapter.ID, path: chapter.Path, freqs: freqs, length: len(terms)})
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.closed {
+		return ErrClosed
+	}
+	ix.remove(key)
+	record := &bookRecord{Book: Book{Key: key, Title: title, Source: source, Chapters: len(chapters)}}
+	for i, ch := range chapters {
+		doc := ix.state.NextDoc
+		ix.state.NextDoc++
+		ix.state.Docs[doc] = docRecord{Book: key, ChapterIndex: i, ChapterID: ch.id, Path: ch.path, Length: ch.length}
+		ix.state.TotalLength += int64(ch.length)
+		record.Docs = append(record.Docs, doc)
+		for term, tf := range ch.freqs {
+			ix.pending[term] = append(ix.pending[term], posting{doc: doc, tf: tf})
+		}
+	}
+	ix.state.Books[key] = record
+	ix.dirty = true
+	return nil
+}
+
+// Remove deletes the book with the given unique identifier; the removal is
+// persisted by Flush or Close. Its postings stay in the segment files until
+// the next Compact but no longer match.
+func (ix *Index) Remove(key string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.closed {
+		return ErrClosed
+	}
+	if ix.remove(key) {
+		ix.dirty = true
+	}
+	return nil
+}
+
+func (ix *Index) remove(key string) bool {
+	record, ok := ix.state.Books[key]
+	if !ok {
+		return false
+	}
+	for _, doc := range record.Docs {
+		ix.state.TotalLength -= int64(ix.state.Docs[doc].Length)
+		delete(ix.state.Docs, doc)
+	}
+	delete(ix.state.Books, key)
+	return true
+}
+
+// Books lists the indexed books sorted by key.
+func (ix *Index) Books() []Book {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	books := make([]Book, 0, len(ix.state.Books))
+	for _, record := range ix.state.Books {
+		books = append(books, record.Book)
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].Key < books[j].Key })
+	return books
+}
+
+// Contains reports whether a book with the given unique identifier is
+// indexed.
+func (ix *Index) Contains(key string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	_, ok := ix.state.Books[key]
+	return ok
+}
+
+// Search returns up to limit chapters ranked by their BM25 score for query.
+// A limit of zero or less returns every matching chapter.
+func (ix *Index) Search(query string, limit int) ([]Hit, error) {
+	terms := ix.opts.Tokenizer.Tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	queryFreqs := make(map[string]int)
+	for _, term := range terms {
+		queryFreqs[term]++
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if ix.closed {
+		return nil, ErrClosed
+	}
+	n := float64(len(ix.state.Docs))
+	if n == 0 {
+		return nil, nil
+	}
+	avgLength := float64(ix.state.TotalLength) / n
+	if avgLength == 0 {
+		avgLength = 1
+	}
+
+	scores := make(map[uint32]float64)
+	for term, qf := range queryFreqs {
+		list, err := ix.livePostings(term)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			continue
+		}
+		df := float64(len(list))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range list {
+			length := float64(ix.state.Docs[p.doc].Length)
+			tf := float64(p.tf)
+			norm := tf + ix.opts.K1*(1-ix.opts.B+ix.opts.B*length/avgLength)
+			scores[p.doc] += float64(qf) * idf * tf * (ix.opts.K1 + 1) / norm
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for doc, score := range scores {
+		record := ix.state.Docs[doc]
+		hits = append(hits, Hit{
+			Book:         ix.state.Books[record.Book].Book,
+			ChapterIndex: record.ChapterIndex,
+			ChapterID:    record.ChapterID,
+			Path:         record.Path,
+			Score:        score,
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Book.Key != hits[j].Book.Key {
+			return hits[i].Book.Key < hits[j].Book.Key
+		}
+		return hits[i].ChapterIndex < hits[j].ChapterIndex
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// livePostings collects the postings of term from every segment and the
+// pending buffer, skipping documents of removed books.
+func (ix *Index) livePostings(term string) ([]posting, error) {
+	var out []posting
+	appendLive := func(list []posting) {
+		for _, p := range list {
+			if _, ok := ix.state.Docs[p.doc]; ok {
+				out = append(out, p)
+			}
+		}
+	}
+	for _, seg := range ix.segments {
+		list, err := seg.postings(term)
+		if err != nil {
+			return nil, err
+		}
+		appendLive(list)
+	}
+	appendLive(ix.pending[term])
+	return out, nil
+}
+
+// Flush writes pending additions to a new segment and persists the index
+// state. When more than Options.MaxSegments segments exist afterwards the
+// index is compacted.
+func (ix *Index) Flush() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.closed {
+		return ErrClosed
+	}
+	return ix.flush()
+}
+
+func (ix *Index) flush() error {
+	if len(ix.pending) > 0 {
+		name := ix.nextSegmentName()
+		pending := ix.pending
+		err := writeSegment(filepath.Join(ix.dir, name), sortedTerms(pending), func(term string) ([]posting, error) {
+			return pending[term], nil
+		})
+		if err != nil {
+			return err
+		}
+		seg, err := openSegment(filepath.Join(ix.dir, name), name)
+		if err != nil {
+			return err
+		}
+		ix.segments = append(ix.segments, seg)
+		ix.state.Segments = append(ix.state.Segments, name)
+		ix.pending = make(map[string][]posting)
+		ix.dirty = true
+	}
+	if ix.opts.MaxSegments > 0 && len(ix.segments) > ix.opts.MaxSegments {
+		return ix.compact()
+	}
+	if !ix.dirty {
+		return nil
+	}
+	return ix.saveManifest()
+}
+
+// Compact flushes pending additions and merges every segment into one,
+// dropping the postings of removed books.
+func (ix *Index) Compact() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.closed {
+		return ErrClosed
+	}
+	return ix.compact()
+}
+
+func (ix *Index) compact() error {
+	terms := make(map[string]struct{})
+	for _, seg := range ix.segments {
+		for term := range seg.dict {
+			terms[term] = struct{}{}
+		}
+	}
+	for term := range ix.pending {
+		terms[term] = struct{}{}
+	}
+	// Document IDs grow with every addition, so concatenating segments in
+	// creation order keeps each merged postings list sorted.
+	name := ix.nextSegmentName()
+	if err := writeSegment(filepath.Join(ix.dir, name), sortedTerms(terms), ix.livePostings); err != nil {
+		return err
+	}
+	seg, err := openSegment(filepath.Join(ix.dir, name), name)
+	if err != nil {
+		return err
+	}
+	old := ix.segments
+	ix.segments = []*segment{seg}
+	ix.state.Segments = []string{name}
+	ix.pending = make(map[string][]posting)
+	if err := ix.saveManifest(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range old {
+		errs = append(errs, s.close(), os.Remove(filepath.Join(ix.dir, s.name)))
+	}
+	return errors.Join(errs...)
+}
+
+func (ix *Index) nextSegmentName() string {
+	ix.state.NextSegment++
+	return fmt.Sprintf("seg-%06d.idx", ix.state.NextSegment)
+}
+
+// saveManifest atomically replaces the manifest file.
+func (ix *Index) saveManifest() error {
+	path := filepath.Join(ix.dir, manifestName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(&ix.state)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	ix.dirty = false
+	return nil
+}
+
+// Close flushes pending changes and releases the segment files.
+func (ix *Index) Close() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.closed {
+		return nil
+	}
+	err := ix.flush()
+	ix.closed = true
+	return errors.Join(err, ix.closeSegments())
+}
+
+func (ix *Index) closeSegments() error {
+	var errs []error
+	for _, seg := range ix.segments {
+		errs = append(errs, seg.close())
+	}
+	ix.segments = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,209 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Segment files are immutable:
+//
+//	magic | postings lists ... | gob-encoded dictionary | dictionary offset (uint64) | magic
+//
+// Each postings list is a uvarint count followed by (docID delta, term
+// frequency) uvarint pairs in ascending docID order.
+var segmentMagic = [4]byte{'E', 'P', 'I', 'X'}
+
+// ErrCorrupt indicates that an index file could not be decoded.
+var ErrCorrupt = errors.New("corrupt index file")
+
+// posting records how often a term occurs in a chapter document.
+type posting struct {
+	doc uint32
+	tf  uint32
+}
+
+// dictEntry locates the postings list of a term inside a segment file.
+type dictEntry struct {
+	Offset int64
+	Length int64
+}
+
+// segment is an open, read-only segment file.
+type segment struct {
+	name string
+	file *os.File
+	dict map[string]dictEntry
+}
+
+func openSegment(path, name string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := readSegment(f, name)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("segment %s: %w", name, err), f.Close())
+	}
+	return seg, nil
+}
+
+func readSegment(f *os.File, name string) (*segment, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(2*len(segmentMagic)+8) {
+		return nil, ErrCorrupt
+	}
+	trailer := make([]byte, 8+len(segmentMagic))
+	if _, err := f.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(trailer[8:], segmentMagic[:]) {
+		return nil, ErrCorrupt
+	}
+	dictOffset := int64(binary.LittleEndian.Uint64(trailer[:8]))
+	if dictOffset < int64(len(segmentMagic)) || dictOffset > size-int64(len(trailer)) {
+		return nil, ErrCorrupt
+	}
+	var dict map[string]dictEntry
+	section := io.NewSectionReader(f, dictOffset, size-int64(len(trailer))-dictOffset)
+	if err := gob.NewDecoder(section).Decode(&dict); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return &segment{name: name, file: f, dict: dict}, nil
+}
+
+// postings reads the postings list of term, or nil when the segment does not
+// contain it.
+func (s *segment) postings(term string) ([]posting, error) {
+	entry, ok := s.dict[term]
+	if !ok {
+		return nil, nil
+	}
+	buf := make([]byte, entry.Length)
+	if _, err := s.file.ReadAt(buf, entry.Offset); err != nil {
+		return nil, fmt.Errorf("segment %s: %w", s.name, err)
+	}
+	list, err := decodePostings(buf)
+	if err != nil {
+		return nil, fmt.Errorf("segment %s: %w", s.name, err)
+	}
+	return list, nil
+}
+
+func (s *segment) close() error {
+	return s.file.Close()
+}
+
+func encodePostings(dst []byte, list []posting) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(list)))
+	var prev uint32
+	for _, p := range list {
+		dst = binary.AppendUvarint(dst, uint64(p.doc-prev))
+		dst = binary.AppendUvarint(dst, uint64(p.tf))
+		prev = p.doc
+	}
+	return dst
+}
+
+func decodePostings(buf []byte) ([]posting, error) {
+	r := bytes.NewReader(buf)
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(len(buf)) {
+		return nil, ErrCorrupt
+	}
+	list := make([]posting, 0, n)
+	var doc uint64
+	for range n {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		tf, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		doc += delta
+		list = append(list, posting{doc: uint32(doc), tf: uint32(tf)})
+	}
+	return list, nil
+}
+
+// writeSegment writes a segment file holding the postings returned by next
+// for every term in terms, which must be sorted. The file is written to a
+// temporary name and renamed into place once complete.
+func writeSegment(path string, terms []string, next func(term string) ([]posting, error)) (err error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(segmentMagic[:]); err != nil {
+		return err
+	}
+	offset := int64(len(segmentMagic))
+	dict := make(map[string]dictEntry, len(terms))
+	var buf []byte
+	for _, term := range terms {
+		list, err := next(term)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			continue
+		}
+		buf = encodePostings(buf[:0], list)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		dict[term] = dictEntry{Offset: offset, Length: int64(len(buf))}
+		offset += int64(len(buf))
+	}
+	if err := gob.NewEncoder(w).Encode(dict); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint64(trailer[:], uint64(offset))
+	if _, err := w.Write(trailer[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(segmentMagic[:]); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sortedTerms returns the keys of m in ascending order.
+func sortedTerms[V any](m map[string]V) []string {
+	terms := make([]string, 0, len(m))
+	for term := range m {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms
+}
@@ -0,0 +1,116 @@
+package index
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/ArcadiaLin/go-epub/internal/cjk"
+)
+
+// Tokenizer splits text into index terms. The same tokenizer must be used for
+// indexing and querying, so its name is recorded in the index and checked
+// when the index is reopened.
+type Tokenizer interface {
+	Name() string
+	Tokenize(text string) []string
+}
+
+// WordTokenizer splits text at every character that is not a letter, digit
+// or combining mark. Terms are NFKC normalised and case folded. It suits
+// Latin, Cyrillic, Greek and other space-delimited scripts.
+type WordTokenizer struct{}
+
+func (WordTokenizer) Name() string { return "word" }
+
+func (WordTokenizer) Tokenize(text string) []string {
+	var terms []string
+	for _, run := range runs(text) {
+		terms = append(terms, run.text)
+	}
+	return terms
+}
+
+// BigramTokenizer emits the overlapping character bigrams of every run of
+// letters, and single-character runs as unigrams. It suits Chinese,
+// Japanese and Korean text, which has no spaces between words.
+type BigramTokenizer struct{}
+
+func (BigramTokenizer) Name() string { return "bigram" }
+
+func (BigramTokenizer) Tokenize(text string) []string {
+	var terms []string
+	for _, run := range runs(text) {
+		terms = appendBigrams(terms, run.text)
+	}
+	return terms
+}
+
+// MixedTokenizer applies BigramTokenizer to CJK runs and WordTokenizer to
+// everything else. It is the default tokenizer of an Index.
+type MixedTokenizer struct{}
+
+func (MixedTokenizer) Name() string { return "mixed" }
+
+func (MixedTokenizer) Tokenize(text string) []string {
+	var terms []string
+	for _, run := range runs(text) {
+		if run.cjk {
+			terms = appendBigrams(terms, run.text)
+			continue
+		}
+		terms = append(terms, run.text)
+	}
+	return terms
+}
+
+func appendBigrams(terms []string, text string) []string {
+	chars := []rune(text)
+	if len(chars) == 1 {
+		return append(terms, text)
+	}
+	for i := 0; i+1 < len(chars); i++ {
+		terms = append(terms, string(chars[i:i+2]))
+	}
+	return terms
+}
+
+// run is a maximal sequence of word characters of the same kind.
+type run struct {
+	text string
+	cjk  bool
+}
+
+// runs normalises text and splits it into word runs. A change between CJK
+// and other scripts also ends a run, so "EPUB电子书" yields two runs.
+func runs(text string) []run {
+	text = cases.Fold().String(norm.NFKC.String(text))
+	var out []run
+	var sb strings.Builder
+	inCJK := false
+	flush := func() {
+		if sb.Len() > 0 {
+			out = append(out, run{text: sb.String(), cjk: inCJK})
+			sb.Reset()
+		}
+	}
+	for _, r := range text {
+		if !isWordRune(r) {
+			flush()
+			continue
+		}
+		if c := cjk.Is(r); c != inCJK {
+			flush()
+			inCJK = c
+		}
+		sb.WriteRune(r)
+	}
+	flush()
+	return out
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
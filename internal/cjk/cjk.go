@@ -0,0 +1,16 @@
+// Package cjk classifies the characters of Chinese, Japanese and Korean
+// text, which is written without spaces between words.
+package cjk
+
+import "unicode"
+
+// Is reports whether r belongs to a script written without word spaces: Han,
+// Hiragana, Katakana and Hangul, the katakana prolonged sound mark, which
+// belongs to the Common script, CJK punctuation and the full-width and
+// half-width forms.
+func Is(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		r == 'ー' ||
+		(r >= 0x3000 && r <= 0x303F) || // CJK Symbols and Punctuation
+		(r >= 0xFF00 && r <= 0xFFEF) // Halfwidth and Fullwidth Forms
+}
@@ -0,0 +1,143 @@
+package lcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	epub "github.com/ArcadiaLin/go-epub"
+)
+
+// Passphrase returns an unlocker for books whose license accepts passphrase.
+func Passphrase(passphrase string) epub.Unlocker {
+	return HashedPassphrase(HashPassphrase(passphrase))
+}
+
+// HashedPassphrase returns an unlocker for books whose license accepts
+// userKey, the hashed passphrase as returned by HashPassphrase.
+func HashedPassphrase(userKey []byte) epub.Unlocker {
+	return unlocker{userKey: userKey}
+}
+
+type unlocker struct {
+	userKey []byte
+}
+
+// Unlock reads the license of the book, validates the user key and checks the
+// rights period.
+func (u unlocker) Unlock(fsys fs.FS, protection epub.Protection) (epub.Decrypter, error) {
+	if protection.Scheme != epub.DRMReadiumLCP {
+		return nil, fmt.Errorf("%w: book is protected by %s", ErrUnsupportedProfile, protection.Scheme)
+	}
+	data, err := fs.ReadFile(fsys, LicensePath)
+	if err != nil {
+		return nil, fmt.Errorf("read license: %w", err)
+	}
+	license, err := ParseLicense(data)
+	if err != nil {
+		return nil, err
+	}
+	if !license.Valid(time.Now()) {
+		return nil, ErrLicenseExpired
+	}
+	key, err := license.ContentKey(u.userKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecrypter(key), nil
+}
+
+// Decrypter decrypts the resources of a book with its content key.
+type Decrypter struct {
+	key []byte
+}
+
+// NewDecrypter returns a Decrypter using contentKey, as returned by
+// License.ContentKey.
+func NewDecrypter(contentKey []byte) *Decrypter {
+	return &Decrypter{key: contentKey}
+}
+
+// Decrypt decrypts the stored bytes of res and inflates them when the
+// resource was deflated before encryption. Inflated content longer than
+// maxSize, when positive, is rejected with an *epub.LimitError.
+func (d *Decrypter) Decrypt(res epub.EncryptedResource, data []byte, maxSize int64) ([]byte, error) {
+	if res.Algorithm != AlgorithmAES256CBC {
+		return nil, fmt.Errorf("%w: resource algorithm %s", ErrUnsupportedProfile, res.Algorithm)
+	}
+	plain, err := decryptCBC(d.key, data)
+	if err != nil {
+		return nil, err
+	}
+	switch res.Compression {
+	case 0:
+		return plain, nil
+	case 8:
+		plain, err = inflate(plain, res.OriginalLength, maxSize)
+		var limitErr *epub.LimitError
+		if errors.As(err, &limitErr) {
+			limitErr.Name = res.Path
+		}
+		return plain, err
+	default:
+		return nil, fmt.Errorf("unsupported compression method %d", res.Compression)
+	}
+}
+
+// decryptCBC decrypts AES-CBC data whose first block is the IV and removes
+// the PKCS#7 padding.
+func decryptCBC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a whole number of blocks")
+	}
+	iv, ciphertext := data[:aes.BlockSize], data[aes.BlockSize:]
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ciphertext)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// inflate decompresses raw deflate data. originalLength, when known, sizes
+// the buffer and bounds the output. Output longer than maxSize, when
+// positive, fails with a *epub.LimitError as soon as it is produced.
+func inflate(data []byte, originalLength, maxSize int64) ([]byte, error) {
+	if maxSize > 0 && originalLength > maxSize {
+		return nil, &epub.LimitError{Limit: "entry size", Value: originalLength, Max: maxSize}
+	}
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	var buf bytes.Buffer
+	var src io.Reader = r
+	switch {
+	case originalLength > 0:
+		buf.Grow(int(min(originalLength, 64<<20)))
+		src = io.LimitReader(r, originalLength)
+	case maxSize > 0:
+		src = io.LimitReader(r, maxSize+1)
+	}
+	if _, err := buf.ReadFrom(src); err != nil {
+		return nil, fmt.Errorf("inflate: %w", err)
+	}
+	if maxSize > 0 && int64(buf.Len()) > maxSize {
+		return nil, &epub.LimitError{Limit: "entry size", Value: int64(buf.Len()), Max: maxSize}
+	}
+	return buf.Bytes(), nil
+}
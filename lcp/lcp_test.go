@@ -0,0 +1,310 @@
+package lcp
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	epub "github.com/ArcadiaLin/go-epub"
+)
+
+const (
+	testLicenseID  = "urn:uuid:8f3a8e0c-6d2b-4b8e-9a52-1b6f0b3a7c11"
+	testPassphrase = "correct horse battery staple"
+)
+
+// encryptCBC encrypts data with AES-CBC and PKCS#7 padding, prefixing the IV,
+// as LCP encrypts key checks, content keys and resources.
+func encryptCBC(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	out := make([]byte, aes.BlockSize+len(plain))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], plain)
+	return out
+}
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newLicense builds a basic profile license for passphrase protecting
+// contentKey.
+func newLicense(t *testing.T, passphrase string, contentKey []byte) *License {
+	userKey := HashPassphrase(passphrase)
+	return &License{
+		ID:       testLicenseID,
+		Issued:   time.Now().Add(-time.Hour).UTC(),
+		Provider: "https://provider.example",
+		Encryption: Encryption{
+			Profile: BasicProfile,
+			ContentKey: ContentKey{
+				Algorithm:      AlgorithmAES256CBC,
+				EncryptedValue: encryptCBC(t, userKey, contentKey),
+			},
+			UserKey: UserKey{
+				Algorithm: AlgorithmSHA256,
+				TextHint:  "The usual one",
+				KeyCheck:  encryptCBC(t, userKey, []byte(testLicenseID)),
+			},
+		},
+	}
+}
+
+// testBook is a one-chapter book whose chapter is deflated and encrypted with
+// the content key of license.
+type testBook struct {
+	files   fstest.MapFS
+	chapter string
+}
+
+func newTestBook(t *testing.T, license *License, contentKey []byte) testBook {
+	t.Helper()
+	w := epub.NewWriter()
+	w.SetTitle("Borrowed")
+	w.SetIdentifier("urn:uuid:0d6c1a3e-5d1e-4f57-8f57-5b0e4c8f2d90")
+	if _, err := w.AddChapter("Chapter One", "<p>The secret text of the first chapter.</p>"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := testBook{files: fstest.MapFS{}}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		book.files[f.Name] = &fstest.MapFile{Data: data}
+		if bytes.Contains(data, []byte("secret text")) {
+			book.chapter = f.Name
+		}
+	}
+	if book.chapter == "" {
+		t.Fatal("chapter not found in written book")
+	}
+
+	plain := book.files[book.chapter].Data
+	book.files[book.chapter] = &fstest.MapFile{Data: encryptCBC(t, contentKey, deflate(t, plain))}
+	book.files["META-INF/encryption.xml"] = &fstest.MapFile{Data: []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#" xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="%s"/>
+    <ds:KeyInfo>
+      <ds:RetrievalMethod URI="license.lcpl#/encryption/content_key" Type="http://readium.org/2014/01/lcp#EncryptedContentKey"/>
+    </ds:KeyInfo>
+    <enc:CipherData>
+      <enc:CipherReference URI="%s"/>
+    </enc:CipherData>
+    <enc:EncryptionProperties>
+      <enc:EncryptionProperty xmlns:ns="http://www.idpf.org/2016/encryption#compression">
+        <ns:Compression Method="8" OriginalLength="%d"/>
+      </enc:EncryptionProperty>
+    </enc:EncryptionProperties>
+  </enc:EncryptedData>
+</encryption>`, AlgorithmAES256CBC, book.chapter, len(plain)))}
+	book.setLicense(t, license)
+	return book
+}
+
+func (b testBook) setLicense(t *testing.T, license *License) {
+	t.Helper()
+	data, err := json.Marshal(license)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.files[LicensePath] = &fstest.MapFile{Data: data}
+}
+
+func newContentKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestReadBookWithPassphrase(t *testing.T) {
+	contentKey := newContentKey(t)
+	book := newTestBook(t, newLicense(t, testPassphrase, contentKey), contentKey)
+
+	if _, err := epub.ReadBookFS(book.files); !errors.Is(err, epub.ErrDRMProtected) {
+		t.Fatalf("ReadBookFS without unlocker: err = %v, want ErrDRMProtected", err)
+	}
+
+	for _, lazy := range []bool{false, true} {
+		b, err := epub.ReadBookFS(book.files, epub.ReadOptions{DRM: Passphrase(testPassphrase), LazyChapters: lazy})
+		if err != nil {
+			t.Fatalf("lazy=%v: ReadBookFS: %v", lazy, err)
+		}
+		chapter, err := b.ChapterByIndex(0)
+		if err != nil {
+			t.Fatalf("lazy=%v: ChapterByIndex: %v", lazy, err)
+		}
+		if got, want := chapter.Text(), "The secret text of the first chapter."; got != want {
+			t.Errorf("lazy=%v: chapter text = %q, want %q", lazy, got, want)
+		}
+		if chapter.Title != "Chapter One" {
+			t.Errorf("lazy=%v: chapter title = %q", lazy, chapter.Title)
+		}
+		rc, err := b.OpenResource(book.chapter)
+		if err != nil {
+			t.Fatalf("lazy=%v: OpenResource: %v", lazy, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !strings.Contains(string(data), "<html") {
+			t.Errorf("lazy=%v: OpenResource returned %q, %v", lazy, data, err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	contentKey := newContentKey(t)
+	book := newTestBook(t, newLicense(t, testPassphrase, contentKey), contentKey)
+
+	_, err := epub.ReadBookFS(book.files, epub.ReadOptions{DRM: Passphrase("wrong")})
+	if !errors.Is(err, ErrInvalidPassphrase) {
+		t.Fatalf("err = %v, want ErrInvalidPassphrase", err)
+	}
+
+	license, err := ParseLicense(book.files[LicensePath].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := license.CheckUserKey(HashPassphrase(testPassphrase)); err != nil {
+		t.Errorf("CheckUserKey(correct) = %v", err)
+	}
+	if _, err := license.ContentKey(HashPassphrase("wrong")); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("ContentKey(wrong) = %v, want ErrInvalidPassphrase", err)
+	}
+	key, err := license.ContentKey(HashPassphrase(testPassphrase))
+	if err != nil || !bytes.Equal(key, contentKey) {
+		t.Errorf("ContentKey(correct) = %x, %v; want %x", key, err, contentKey)
+	}
+}
+
+func TestExpiredLicense(t *testing.T) {
+	contentKey := newContentKey(t)
+	license := newLicense(t, testPassphrase, contentKey)
+	end := time.Now().Add(-time.Minute).UTC()
+	license.Rights.End = &end
+	book := newTestBook(t, license, contentKey)
+
+	_, err := epub.ReadBookFS(book.files, epub.ReadOptions{DRM: Passphrase(testPassphrase)})
+	if !errors.Is(err, ErrLicenseExpired) {
+		t.Fatalf("err = %v, want ErrLicenseExpired", err)
+	}
+	if license.Valid(time.Now()) {
+		t.Error("Valid(now) = true after rights end")
+	}
+	if !license.Valid(end.Add(-time.Minute)) {
+		t.Error("Valid(before end) = false")
+	}
+	start := time.Now().Add(time.Hour)
+	license.Rights.End, license.Rights.Start = nil, &start
+	if license.Valid(time.Now()) {
+		t.Error("Valid(now) = true before rights start")
+	}
+}
+
+func TestCorruptPadding(t *testing.T) {
+	key := newContentKey(t)
+	data := encryptCBC(t, key, []byte("sixteen byte msg"))
+
+	// Flipping a byte of the IV corrupts the same byte of the first plain
+	// block; flipping the last byte of the next-to-last block corrupts the
+	// padding.
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-aes.BlockSize-1] ^= 0x5a
+	if plain, err := decryptCBC(key, corrupt); err == nil {
+		t.Errorf("decryptCBC(corrupt padding) = %q, want error", plain)
+	}
+	if _, err := decryptCBC(key, data[:len(data)-1]); err == nil {
+		t.Error("decryptCBC(truncated) succeeded")
+	}
+	if _, err := decryptCBC(key, data[:aes.BlockSize]); err == nil {
+		t.Error("decryptCBC(IV only) succeeded")
+	}
+	if plain, err := decryptCBC(key, data); err != nil || string(plain) != "sixteen byte msg" {
+		t.Errorf("decryptCBC = %q, %v", plain, err)
+	}
+
+	d := NewDecrypter(key)
+	res := epub.EncryptedResource{Path: "OEBPS/c.xhtml", Algorithm: AlgorithmAES256CBC}
+	if plain, err := d.Decrypt(res, corrupt, 0); err == nil {
+		t.Errorf("Decrypt(corrupt padding) = %q, want error", plain)
+	}
+	res.Compression = 8
+	if plain, err := d.Decrypt(res, encryptCBC(t, key, []byte("not deflate data \xff\xff")), 0); err == nil {
+		t.Errorf("Decrypt(corrupt deflate) = %q, want error", plain)
+	}
+	text := strings.Repeat("inflated ", 100)
+	res.OriginalLength = int64(len(text))
+	if plain, err := d.Decrypt(res, encryptCBC(t, key, deflate(t, []byte(text))), 0); err != nil || string(plain) != text {
+		t.Errorf("Decrypt(deflated) = %q, %v", plain, err)
+	}
+}
+
+func TestInflateLimit(t *testing.T) {
+	key := newContentKey(t)
+	d := NewDecrypter(key)
+	// Without OriginalLength only maxSize bounds the inflated content.
+	res := epub.EncryptedResource{Path: "OEBPS/bomb.xhtml", Algorithm: AlgorithmAES256CBC, Compression: 8}
+	bomb := encryptCBC(t, key, deflate(t, make([]byte, 1<<20)))
+	var limitErr *epub.LimitError
+	if _, err := d.Decrypt(res, bomb, 1000); !errors.As(err, &limitErr) || limitErr.Name != res.Path || limitErr.Max != 1000 {
+		t.Errorf("Decrypt(bomb, 1000) error = %v, want entry size limit", err)
+	}
+	if plain, err := d.Decrypt(res, bomb, 1<<20); err != nil || len(plain) != 1<<20 {
+		t.Errorf("Decrypt(bomb, 1 MiB) = %d bytes, %v", len(plain), err)
+	}
+	res.OriginalLength = 1 << 20
+	if _, err := d.Decrypt(res, bomb, 1000); !errors.As(err, &limitErr) {
+		t.Errorf("Decrypt(declared 1 MiB, 1000) error = %v, want entry size limit", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Package lcp decrypts EPUB publications protected by Readium LCP.
+//
+// An LCP book carries its license in META-INF/license.lcpl. The user key is
+// derived from the passphrase chosen by the user, checked against the key
+// check of the license and used to decrypt the content key, which in turn
+// decrypts the resources listed in META-INF/encryption.xml. Passphrase
+// returns an epub.Unlocker for ReadOptions.DRM, so chapters and resources of
+// the book read like those of an unprotected one:
+//
+//	book, err := epub.ReadBook(path, epub.ReadOptions{DRM: lcp.Passphrase(passphrase)})
+//
+// Only the basic encryption profile is supported; production profiles rely
+// on a transformation that is not public. The license signature and its
+// status document are not checked, and no license server is contacted.
+package lcp
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Profiles and algorithms of LCP licenses.
+const (
+	BasicProfile = "http://readium.org/lcp/basic-profile"
+
+	AlgorithmAES256CBC = "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
+	AlgorithmSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// LicensePath is the container path of the license document.
+const LicensePath = "META-INF/license.lcpl"
+
+var (
+	// ErrInvalidLicense indicates that a license document is malformed.
+	ErrInvalidLicense = errors.New("invalid lcp license")
+	// ErrUnsupportedProfile indicates that a license uses an encryption
+	// profile or algorithm this package does not implement.
+	ErrUnsupportedProfile = errors.New("unsupported lcp encryption profile")
+	// ErrInvalidPassphrase indicates that the user key does not pass the key
+	// check of the license.
+	ErrInvalidPassphrase = errors.New("invalid lcp passphrase")
+	// ErrLicenseExpired indicates that the current time is outside the
+	// rights period of the license.
+	ErrLicenseExpired = errors.New("lcp license is not valid at this time")
+)
+
+// License is a parsed license.lcpl document.
+type License struct {
+	ID         string     `json:"id"`
+	Issued     time.Time  `json:"issued"`
+	Updated    *time.Time `json:"updated,omitempty"`
+	Provider   string     `json:"provider"`
+	Encryption Encryption `json:"encryption"`
+	Links      []Link     `json:"links,omitempty"`
+	User       User       `json:"user,omitempty"`
+	Rights     Rights     `json:"rights,omitempty"`
+	Signature  Signature  `json:"signature"`
+}
+
+// Encryption describes how the content key and the user key are protected.
+type Encryption struct {
+	Profile    string     `json:"profile"`
+	ContentKey ContentKey `json:"content_key"`
+	UserKey    UserKey    `json:"user_key"`
+}
+
+// ContentKey is the key encrypting the resources, itself encrypted with the
+// user key.
+type ContentKey struct {
+	Algorithm      string `json:"algorithm"`
+	EncryptedValue []byte `json:"encrypted_value"` // IV followed by the ciphertext
+}
+
+// UserKey describes how the user key is derived from the passphrase.
+type UserKey struct {
+	Algorithm string `json:"algorithm"`
+	TextHint  string `json:"text_hint,omitempty"` // shown to the user when asking for the passphrase
+	// KeyCheck is the license ID encrypted with the user key.
+	KeyCheck []byte `json:"key_check"`
+}
+
+// Link is a link of the license, e.g. to the publication or the status
+// document.
+type Link struct {
+	Rel       string `json:"rel"`
+	Href      string `json:"href"`
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Length    int64  `json:"length,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+// User identifies the licensee. Fields named in Encrypted are encrypted with
+// the content key.
+type User struct {
+	ID        string   `json:"id,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Encrypted []string `json:"encrypted,omitempty"`
+}
+
+// Rights lists the rights granted by the license. Nil fields are unlimited.
+type Rights struct {
+	Print *int       `json:"print,omitempty"` // number of pages that may be printed
+	Copy  *int       `json:"copy,omitempty"`  // number of characters that may be copied
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Signature is the provider's signature of the license. It is parsed but not
+// verified.
+type Signature struct {
+	Algorithm   string `json:"algorithm"`
+	Certificate []byte `json:"certificate"`
+	Value       []byte `json:"value"`
+}
+
+// ParseLicense parses a license.lcpl document.
+func ParseLicense(data []byte) (*License, error) {
+	var license License
+	if err := json.Unmarshal(data, &license); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLicense, err)
+	}
+	if license.ID == "" {
+		return nil, fmt.Errorf("%w: missing id", ErrInvalidLicense)
+	}
+	if len(license.Encryption.ContentKey.EncryptedValue) == 0 || len(license.Encryption.UserKey.KeyCheck) == 0 {
+		return nil, fmt.Errorf("%w: missing content key or key check", ErrInvalidLicense)
+	}
+	return &license, nil
+}
+
+// HashPassphrase returns the user key of the basic profile, the SHA-256 of
+// the passphrase. Reading systems usually store this hash rather than the
+// passphrase itself.
+func HashPassphrase(passphrase string) []byte {
+	sum := sha256.Sum256([]byte(passphrase))
+	return sum[:]
+}
+
+// checkProfile reports whether the license can be decrypted by this package.
+func (l *License) checkProfile() error {
+	enc := l.Encryption
+	if enc.Profile != BasicProfile {
+		return fmt.Errorf("%w: %s", ErrUnsupportedProfile, enc.Profile)
+	}
+	if enc.UserKey.Algorithm != "" && enc.UserKey.Algorithm != AlgorithmSHA256 {
+		return fmt.Errorf("%w: user key algorithm %s", ErrUnsupportedProfile, enc.UserKey.Algorithm)
+	}
+	if enc.ContentKey.Algorithm != "" && enc.ContentKey.Algorithm != AlgorithmAES256CBC {
+		return fmt.Errorf("%w: content key algorithm %s", ErrUnsupportedProfile, enc.ContentKey.Algorithm)
+	}
+	return nil
+}
+
+// CheckUserKey validates userKey against the key check of the license.
+func (l *License) CheckUserKey(userKey []byte) error {
+	if err := l.checkProfile(); err != nil {
+		return err
+	}
+	id, err := decryptCBC(userKey, l.Encryption.UserKey.KeyCheck)
+	if err != nil || string(id) != l.ID {
+		return ErrInvalidPassphrase
+	}
+	return nil
+}
+
+// ContentKey validates userKey and returns the decrypted content key.
+func (l *License) ContentKey(userKey []byte) ([]byte, error) {
+	if err := l.CheckUserKey(userKey); err != nil {
+		return nil, err
+	}
+	key, err := decryptCBC(userKey, l.Encryption.ContentKey.EncryptedValue)
+	if err != nil {
+		return nil, fmt.Errorf("%w: content key: %v", ErrInvalidLicense, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: content key has %d bytes", ErrInvalidLicense, len(key))
+	}
+	return key, nil
+}
+
+// Valid reports whether t falls inside the rights period of the license.
+func (l *License) Valid(t time.Time) bool {
+	if l.Rights.Start != nil && t.Before(*l.Rights.Start) {
+		return false
+	}
+	if l.Rights.End != nil && t.After(*l.Rights.End) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,283 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Default limits applied by ReadOptions.Limits.
+const (
+	DefaultMaxTotalSize        = 4 << 30
+	DefaultMaxEntrySize        = 256 << 20
+	DefaultMaxCompressionRatio = 100
+	DefaultMaxEntries          = 10000
+	DefaultMaxDepth            = 512
+)
+
+// ratioThreshold exempts small entries from the compression ratio limit:
+// short, repetitive documents legitimately compress very well.
+const ratioThreshold = 1 << 20
+
+var (
+	// ErrLimitExceeded indicates that a book exceeds one of its read limits.
+	// Errors returned for such books are *LimitError values wrapping it.
+	ErrLimitExceeded = errors.New("read limit exceeded")
+	// ErrUnsafePath indicates an archive entry whose name is absolute,
+	// contains a ".." element or is not valid UTF-8.
+	ErrUnsafePath = errors.New("unsafe entry name")
+)
+
+// Limits 限制读取书籍时的资源消耗 / Limits bounds the resources spent reading a book, protecting
+// against zip bombs and hostile documents. Zero fields select the defaults;
+// negative fields disable the limit. Archive-wide limits are checked against
+// the zip headers when the book is opened; the declared sizes are enforced by
+// archive/zip while reading. For books read through ReadBookFS the entry size
+// is enforced while reading and the archive-wide limits do not apply.
+type Limits struct {
+	MaxTotalSize int64 // total uncompressed size of the archive, 4 GiB by default
+	MaxEntrySize int64 // uncompressed size of one entry, 256 MiB by default
+	// MaxCompressionRatio bounds uncompressed/compressed size for entries
+	// larger than 1 MiB, 100 by default.
+	MaxCompressionRatio int64
+	MaxEntries          int // number of archive entries, 10000 by default
+	MaxDepth            int // element nesting of XML and HTML documents, 512 by default
+}
+
+// withDefaults replaces zero limits by their default value.
+func (l Limits) withDefaults() Limits {
+	if l.MaxTotalSize == 0 {
+		l.MaxTotalSize = DefaultMaxTotalSize
+	}
+	if l.MaxEntrySize == 0 {
+		l.MaxEntrySize = DefaultMaxEntrySize
+	}
+	if l.MaxCompressionRatio == 0 {
+		l.MaxCompressionRatio = DefaultMaxCompressionRatio
+	}
+	if l.MaxEntries == 0 {
+		l.MaxEntries = DefaultMaxEntries
+	}
+	if l.MaxDepth == 0 {
+		l.MaxDepth = DefaultMaxDepth
+	}
+	return l
+}
+
+// LimitError reports the limit a book exceeds.
+type LimitError struct {
+	Limit string // "total size", "entry size", "compression ratio", "entries" or "depth"
+	Name  string // entry concerned, empty for archive-wide limits
+	Value int64  // observed value, possibly only the first one over Max
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("%v: %s %d exceeds %d", ErrLimitExceeded, e.Limit, e.Value, e.Max)
+	}
+	return fmt.Sprintf("%v: %s of %s: %d exceeds %d", ErrLimitExceeded, e.Limit, e.Name, e.Value, e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return ErrLimitExceeded }
+
+// checkEntryName rejects entry names that could escape the directory a book
+// is extracted to.
+func checkEntryName(name string) error {
+	switch {
+	case !utf8.ValidString(name):
+		return fmt.Errorf("%w: %q is not valid UTF-8", ErrUnsafePath, name)
+	case strings.HasPrefix(name, "/"), strings.HasPrefix(name, `\`),
+		len(name) >= 2 && name[1] == ':' && ('a' <= name[0]|0x20 && name[0]|0x20 <= 'z'):
+		return fmt.Errorf("%w: %q is absolute", ErrUnsafePath, name)
+	}
+	for _, elem := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return fmt.Errorf("%w: %q leaves the container", ErrUnsafePath, name)
+		}
+	}
+	return nil
+}
+
+// checkArchive validates the entry names and declared sizes of zr.
+func checkArchive(zr *zip.Reader, limits Limits) error {
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return &LimitError{Limit: "entries", Value: int64(len(zr.File)), Max: int64(limits.MaxEntries)}
+	}
+	var total uint64
+	for _, f := range zr.File {
+		if err := checkEntryName(f.Name); err != nil {
+			return err
+		}
+		size := f.UncompressedSize64
+		if limits.MaxEntrySize > 0 && size > uint64(limits.MaxEntrySize) {
+			return &LimitError{Limit: "entry size", Name: f.Name, Value: int64(min(size, 1<<63-1)), Max: limits.MaxEntrySize}
+		}
+		if limits.MaxCompressionRatio > 0 && size > ratioThreshold {
+			ratio := size / max(f.CompressedSize64, 1)
+			if ratio > uint64(limits.MaxCompressionRatio) {
+				return &LimitError{Limit: "compression ratio", Name: f.Name, Value: int64(ratio), Max: limits.MaxCompressionRatio}
+			}
+		}
+		total += size
+		if limits.MaxTotalSize > 0 && total > uint64(limits.MaxTotalSize) {
+			return &LimitError{Limit: "total size", Value: int64(min(total, 1<<63-1)), Max: limits.MaxTotalSize}
+		}
+	}
+	return nil
+}
+
+// limitedFS enforces the entry size limit on the files opened from it.
+type limitedFS struct {
+	fs.FS
+	limits Limits
+}
+
+func (l limitedFS) Open(name string) (fs.File, error) {
+	f, err := l.FS.Open(name)
+	if err != nil || l.limits.MaxEntrySize <= 0 {
+		return f, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return f, nil
+	}
+	if info.Size() > l.limits.MaxEntrySize {
+		f.Close()
+		return nil, &LimitError{Limit: "entry size", Name: name, Value: info.Size(), Max: l.limits.MaxEntrySize}
+	}
+	return &limitedFile{File: f, name: name, max: l.limits.MaxEntrySize}, nil
+}
+
+// zipFiles serves archive entries keyed by name, as they are handed to
+// ParseTOC.
+type zipFiles map[string]*zip.File
+
+func (z zipFiles) Open(name string) (fs.File, error) {
+	f, ok := z[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return zipEntry{ReadCloser: rc, f: f}, nil
+}
+
+// zipEntry is an open archive entry of zipFiles.
+type zipEntry struct {
+	io.ReadCloser
+	f *zip.File
+}
+
+func (e zipEntry) Stat() (fs.FileInfo, error) { return e.f.FileInfo(), nil }
+
+// zipEntryFS serves the single entry f under its name.
+func zipEntryFS(f *zip.File) fs.FS {
+	return limitedFS{FS: zipFiles{f.Name: f}, limits: Limits{}.withDefaults()}
+}
+
+// limitedFile fails reads past the entry size limit, for file systems whose
+// reported sizes cannot be trusted.
+type limitedFile struct {
+	fs.File
+	name string
+	read int64
+	max  int64
+}
+
+func (f *limitedFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.read += int64(n)
+	if f.read > f.max {
+		return n, &LimitError{Limit: "entry size", Name: f.name, Value: f.read, Max: f.max}
+	}
+	return n, err
+}
+
+// maxDepth returns the nesting limit of documents read from fsys.
+func maxDepth(fsys fs.FS) int {
+	switch f := fsys.(type) {
+	case limitedFS:
+		return f.limits.MaxDepth
+	case decryptingFS:
+		return maxDepth(f.FS)
+	}
+	return DefaultMaxDepth
+}
+
+// readXML reads the XML document at name, rejecting it when its elements nest
+// deeper than the limit of fsys.
+func readXML(fsys fs.FS, name string) ([]byte, error) {
+	content, err := getContent(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkXMLDepth(content, maxDepth(fsys)); err != nil {
+		err.Name = name
+		return nil, err
+	}
+	return content, nil
+}
+
+// checkXMLDepth scans the tokens of content for elements nested deeper than
+// limit. Syntax errors are left to the parser.
+func checkXMLDepth(content []byte, limit int) *LimitError {
+	if limit <= 0 {
+		return nil
+	}
+	decoder := xmlNewDecoder(bytes.NewReader(content))
+	depth := 0
+	for {
+		tok, err := decoder.RawToken()
+		if err != nil {
+			return nil
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth > limit {
+				return &LimitError{Limit: "depth", Value: int64(depth), Max: int64(limit)}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// checkHTMLDepth walks the parsed document iteratively, so that the recursive
+// conversion of a hostile document cannot exhaust the stack.
+func checkHTMLDepth(root *html.Node, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	depth := 0
+	for n := root; n != nil; {
+		if n.FirstChild != nil {
+			n = n.FirstChild
+			depth++
+			if depth > limit {
+				return &LimitError{Limit: "depth", Value: int64(depth), Max: int64(limit)}
+			}
+			continue
+		}
+		for n != root && n.NextSibling == nil {
+			n = n.Parent
+			depth--
+		}
+		if n == root {
+			break
+		}
+		n = n.NextSibling
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+package epub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MarkdownOptions tunes Markdown export.
+type MarkdownOptions struct {
+	// ImagePrefix is prepended to image paths, which are otherwise written
+	// relative to the container root (as in Chapter.Images).
+	ImagePrefix string
+	// ChapterWriter enables one file per chapter. It is called with the file
+	// name of every chapter; the main writer then receives an index linking
+	// to the chapter files. Links between chapters point at the right file.
+	ChapterWriter func(name string) (io.WriteCloser, error)
+}
+
+// DirChapterWriter returns a MarkdownOptions.ChapterWriter that creates the
+// chapter files inside dir.
+func DirChapterWriter(dir string) func(name string) (io.WriteCloser, error) {
+	return func(name string) (io.WriteCloser, error) {
+		return os.Create(filepath.Join(dir, name))
+	}
+}
+
+// Markdown renders the chapter as CommonMark with GFM tables. Links are kept
+// as written in the document and images use container paths.
+func (c *Chapter) Markdown() string {
+	if c == nil {
+		return ""
+	}
+	r := &markdownRenderer{
+		link:  func(href string) string { return href },
+		image: func(src string) string { return src },
+	}
+	return r.blocks(c.Blocks)
+}
+
+// ToMarkdown converts the whole book to Markdown in reading order. Every
+// chapter is preceded by an HTML anchor, and links between chapters become
+// in-document anchors (or links to the chapter files when
+// opts.ChapterWriter is set).
+func (b *Book) ToMarkdown(w io.Writer, opts MarkdownOptions) error {
+	if b == nil {
+		return ErrChapterNotFound
+	}
+	chapters := make([]*Chapter, 0, b.ChapterCount())
+	for i := 0; i < b.ChapterCount(); i++ {
+		chapter, err := b.ChapterByIndex(i)
+		if err != nil {
+			return err
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	split := opts.ChapterWriter != nil
+	targets := make(map[string]mdTarget, len(chapters))
+	used := make(anchorSet)
+	for _, c := range chapters {
+		targets[c.Path] = mdTarget{anchor: used.unique(markdownAnchor(c.ID)), ids: make(map[string]string)}
+	}
+	for i, c := range chapters {
+		t := targets[c.Path]
+		if split {
+			t.file = fmt.Sprintf("%03d-%s.md", i+1, t.anchor)
+			targets[c.Path] = t
+		}
+		collectBlockIDs(c.Blocks, t.anchor, t.ids, used)
+	}
+
+	for i, c := range chapters {
+		current := targets[c.Path]
+		r := &markdownRenderer{
+			image: func(src string) string {
+				if isExternalRef(src) {
+					return src
+				}
+				return opts.ImagePrefix + src
+			},
+			link: func(href string) string {
+				return resolveMarkdownLink(c.Path, href, current, targets)
+			},
+			anchors: current.ids,
+			emitted: make(map[string]bool),
+		}
+		body := fmt.Sprintf("<a id=\"%s\"></a>\n\n%s", current.anchor, r.blocks(c.Blocks))
+
+		if !split {
+			if i > 0 {
+				body = "\n" + body
+			}
+			if _, err := io.WriteString(w, body); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeChapterFile(opts.ChapterWriter, current.file, body); err != nil {
+			return err
+		}
+		title := c.Title
+		if title == "" {
+			title = c.ID
+		}
+		if _, err := fmt.Fprintf(w, "- [%s](%s)\n", escapeMarkdown(title), current.file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeChapterFile(create func(string) (io.WriteCloser, error), name, body string) (err error) {
+	wc, err := create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, wc.Close())
+	}()
+	_, err = io.WriteString(wc, body)
+	return err
+}
+
+// mdTarget locates a chapter in the Markdown output.
+type mdTarget struct {
+	file   string            // chapter file in split mode
+	anchor string            // anchor placed before the chapter
+	ids    map[string]string // anchor of every element id of the chapter
+}
+
+func resolveMarkdownLink(chapterPath, href string, current mdTarget, targets map[string]mdTarget) string {
+	if href == "" || isExternalRef(href) || strings.HasPrefix(strings.ToLower(href), "mailto:") {
+		return href
+	}
+	target, fragment := current, ""
+	if p, frag, _ := strings.Cut(href, "#"); p != "" {
+		resolved := archiveName(pathDir(chapterPath), p)
+		t, ok := targets[resolved]
+		if !ok {
+			return href
+		}
+		target, fragment = t, frag
+	} else {
+		fragment = frag
+	}
+	if decoded, err := url.PathUnescape(fragment); err == nil {
+		fragment = decoded
+	}
+	anchor := target.anchor
+	if a, ok := target.ids[fragment]; ok && fragment != "" {
+		anchor = a
+	}
+	if target.file != "" && target.file != current.file {
+		return target.file + "#" + anchor
+	}
+	return "#" + anchor
+}
+
+// collectBlockIDs assigns an anchor, prefixed with the chapter anchor, to the
+// id of every block.
+func collectBlockIDs(blocks []Block, prefix string, ids map[string]string, used anchorSet) {
+	for _, b := range blocks {
+		if _, ok := ids[b.ID]; b.ID != "" && !ok {
+			ids[b.ID] = used.unique(prefix + "-" + markdownAnchor(b.ID))
+		}
+		collectBlockIDs(b.Children, prefix, ids, used)
+		for _, item := range b.Items {
+			collectBlockIDs(item, prefix, ids, used)
+		}
+	}
+}
+
+var anchorUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// markdownAnchor turns an XML id or file name into a stable anchor name.
+func markdownAnchor(id string) string {
+	anchor := strings.Trim(anchorUnsafe.ReplaceAllString(id, "-"), "-")
+	if anchor == "" {
+		return "section"
+	}
+	return anchor
+}
+
+// anchorSet records the anchors used in the output. Distinct ids can map to
+// the same anchor name, such as "ch.1" and "ch-1".
+type anchorSet map[string]bool
+
+// unique returns anchor, or anchor with a numeric suffix if it is taken, and
+// marks the result as used.
+func (s anchorSet) unique(anchor string) string {
+	name := anchor
+	for i := 2; s[name]; i++ {
+		name = anchor + "-" + strconv.Itoa(i)
+	}
+	s[name] = true
+	return name
+}
+
+type markdownRenderer struct {
+	link    func(href string) string
+	image   func(src string) string
+	anchors map[string]string // anchor of each block id; nil disables them
+	emitted map[string]bool   // anchors already written
+}
+
+func (r *markdownRenderer) blocks(blocks []Block) string {
+	var parts []string
+	for _, b := range blocks {
+		if md := r.block(b); md != "" {
+			parts = append(parts, md)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, "\n\n") + "\n"
+}
+
+func (r *markdownRenderer) block(b Block) string {
+	md := r.blockBody(b)
+	if anchor, ok := r.anchors[b.ID]; ok && md != "" && !r.emitted[anchor] {
+		r.emitted[anchor] = true
+		md = fmt.Sprintf("<a id=\"%s\"></a>\n\n%s", anchor, md)
+	}
+	return md
+}
+
+func (r *markdownRenderer) blockBody(b Block) string {
+	switch b.Kind {
+	case BlockHeading:
+		level := min(max(b.Level, 1), 6)
+		return strings.Repeat("#", level) + " " + strings.ReplaceAll(r.inlines(b.Inlines), "\\\n", " ")
+	case BlockParagraph:
+		return escapeLineStart(r.inlines(b.Inlines))
+	case BlockList:
+		var items []string
+		for i, item := range b.Items {
+			marker := "- "
+			if b.Ordered {
+				marker = fmt.Sprintf("%d. ", i+1)
+			}
+			body := strings.TrimRight(r.blocks(item), "\n")
+			items = append(items, marker+indentLines(body, strings.Repeat(" ", len(marker))))
+		}
+		return strings.Join(items, "\n")
+	case BlockQuote:
+		body := strings.TrimRight(r.blocks(b.Children), "\n")
+		lines := strings.Split(body, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return strings.Join(lines, "\n")
+	case BlockTable:
+		return r.table(b)
+	case BlockFigure:
+		var lines []string
+		for _, img := range b.Inlines {
+			lines = append(lines, r.inlines([]Inline{img}))
+		}
+		if caption := r.inlines(b.Caption); caption != "" {
+			lines = append(lines, "*"+caption+"*")
+		}
+		return strings.Join(lines, "\n\n")
+	case BlockPreformatted:
+		fence := "```"
+		for strings.Contains(b.Text, fence) {
+			fence += "`"
+		}
+		return fence + "\n" + strings.TrimRight(b.Text, "\n") + "\n" + fence
+	case BlockRule:
+		return "---"
+	}
+	return ""
+}
+
+func (r *markdownRenderer) table(b Block) string {
+	cols := 0
+	for _, row := range b.Rows {
+		cols = max(cols, len(row))
+	}
+	if cols == 0 {
+		return ""
+	}
+	renderRow := func(row []TableCell) string {
+		cells := make([]string, cols)
+		for i := range cells {
+			if i < len(row) {
+				text := r.inlines(row[i].Inlines)
+				text = strings.ReplaceAll(text, "\\\n", " ")
+				cells[i] = strings.ReplaceAll(text, "|", "\\|")
+			}
+		}
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+	// GFM tables require a header row; use the first row.
+	lines := []string{renderRow(b.Rows[0]), "|" + strings.Repeat(" --- |", cols)}
+	for _, row := range b.Rows[1:] {
+		lines = append(lines, renderRow(row))
+	}
+	if caption := r.inlines(b.Caption); caption != "" {
+		lines = append(lines, "", "*"+caption+"*")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// inlines renders runs, grouping consecutive runs that share a link target.
+func (r *markdownRenderer) inlines(inlines []Inline) string {
+	var sb strings.Builder
+	for i := 0; i < len(inlines); {
+		href := inlines[i].Href
+		j := i + 1
+		for j < len(inlines) && inlines[j].Href == href {
+			j++
+		}
+		var inner strings.Builder
+		for _, in := range inlines[i:j] {
+			inner.WriteString(r.inline(in))
+		}
+		if href == "" {
+			sb.WriteString(inner.String())
+		} else {
+			text := inner.String()
+			lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+			trail := text[len(strings.TrimRight(text, " ")):]
+			fmt.Fprintf(&sb, "%s[%s](%s)%s", lead, strings.TrimSpace(text), markdownURL(r.link(href)), trail)
+		}
+		i = j
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func (r *markdownRenderer) inline(in Inline) string {
+	if in.Image != "" {
+		return fmt.Sprintf("![%s](%s)", escapeMarkdown(in.Text), markdownURL(r.image(in.Image)))
+	}
+	text := in.Text
+	if in.Style&StyleCode != 0 {
+		return wrapTrimmed(text, codeSpan)
+	}
+	text = strings.ReplaceAll(escapeMarkdown(text), "\n", "\\\n")
+	switch {
+	case in.Style&StyleBold != 0 && in.Style&StyleItalic != 0:
+		return wrapTrimmed(text, func(s string) string { return "***" + s + "***" })
+	case in.Style&StyleBold != 0:
+		return wrapTrimmed(text, func(s string) string { return "**" + s + "**" })
+	case in.Style&StyleItalic != 0:
+		return wrapTrimmed(text, func(s string) string { return "*" + s + "*" })
+	}
+	return text
+}
+
+// wrapTrimmed applies wrap to the text without its surrounding spaces, since
+// CommonMark emphasis may not start or end with whitespace.
+func wrapTrimmed(text string, wrap func(string) string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+	return lead + wrap(trimmed) + trail
+}
+
+func codeSpan(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+var listLike = regexp.MustCompile(`^(\d+)([.)])(\s|$)`)
+
+// escapeLineStart prevents the lines of paragraph text from being read as a
+// heading, list item or thematic break.
+func escapeLineStart(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case '#', '+', '-', '=':
+			lines[i] = `\` + line
+			continue
+		}
+		lines[i] = listLike.ReplaceAllString(line, `$1\$2$3`)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownURL(u string) string {
+	if strings.ContainsAny(u, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(u) + ">"
+	}
+	return u
+}
+
+func indentLines(s, indent string) string {
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,280 @@
+package epub
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Creator 对应 dc:creator / dc:contributor 及其 refinements / Creator is a dc:creator or dc:contributor
+// together with the EPUB 3 refinements or EPUB 2 opf attributes describing it.
+type Creator struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Lang string `json:"lang,omitempty"`
+	// Roles holds MARC relator codes such as "aut", "edt" or "trl", in
+	// declaration order.
+	Roles            []string          `json:"roles,omitempty"`
+	FileAs           string            `json:"fileAs,omitempty"` // sort form of the name
+	AlternateScripts []AlternateScript `json:"alternateScripts,omitempty"`
+	DisplaySeq       int               `json:"displaySeq,omitempty"` // 0 when not declared
+}
+
+// Title 对应 dc:title 及其 refinements / Title is a dc:title together with its refinements.
+type Title struct {
+	ID    string `json:"id,omitempty"`
+	Value string `json:"value"`
+	Lang  string `json:"lang,omitempty"`
+	// Type is the EPUB 3 title-type: main, subtitle, short, collection,
+	// edition or expanded. The first title is reported as main when no title
+	// declares that type.
+	Type             string            `json:"type,omitempty"`
+	FileAs           string            `json:"fileAs,omitempty"`
+	AlternateScripts []AlternateScript `json:"alternateScripts,omitempty"`
+	DisplaySeq       int               `json:"displaySeq,omitempty"`
+}
+
+// AlternateScript is a rendering of a name or title in another script, e.g.
+// the Latin transcription of a Japanese author name.
+type AlternateScript struct {
+	Value string `json:"value"`
+	Lang  string `json:"lang,omitempty"`
+}
+
+// Creators returns the dc:creator entries with their roles, file-as forms and
+// alternate scripts, ordered by display-seq when the package declares one and
+// by document order otherwise.
+func (b *Book) Creators() []Creator {
+	return b.people("creator")
+}
+
+// Contributors is Creators for dc:contributor.
+func (b *Book) Contributors() []Creator {
+	return b.people("contributor")
+}
+
+func (b *Book) people(tag string) []Creator {
+	if b == nil || b.Opf == nil || b.Opf.Metadata == nil {
+		return nil
+	}
+	md := b.Opf.Metadata
+	refinements := md.refinements()
+	var people []Creator
+	for _, entry := range md.entries(tag) {
+		name := strings.TrimSpace(entry.Value)
+		if name == "" {
+			continue
+		}
+		c := Creator{
+			ID:     entry.Attrs["id"],
+			Name:   name,
+			Lang:   entry.Attrs["lang"],
+			FileAs: strings.TrimSpace(entry.Attrs["file-as"]),
+		}
+		if role := strings.TrimSpace(entry.Attrs["role"]); role != "" {
+			c.Roles = append(c.Roles, strings.ToLower(role))
+		}
+		for _, ref := range refinements[c.ID] {
+			value := strings.TrimSpace(ref.Value)
+			if value == "" {
+				continue
+			}
+			switch ref.Attrs["property"] {
+			case "role":
+				if role := strings.ToLower(value); !slices.Contains(c.Roles, role) {
+					c.Roles = append(c.Roles, role)
+				}
+			case "file-as":
+				c.FileAs = value
+			case "alternate-script":
+				c.AlternateScripts = append(c.AlternateScripts, AlternateScript{Value: value, Lang: ref.Attrs["lang"]})
+			case "display-seq":
+				c.DisplaySeq, _ = strconv.Atoi(value)
+			}
+		}
+		people = append(people, c)
+	}
+	slices.SortStableFunc(people, func(x, y Creator) int { return compareDisplaySeq(x.DisplaySeq, y.DisplaySeq) })
+	return people
+}
+
+// Titles returns the dc:title entries with their types, file-as forms and
+// alternate scripts, ordered like Creators.
+func (b *Book) Titles() []Title {
+	if b == nil || b.Opf == nil || b.Opf.Metadata == nil {
+		return nil
+	}
+	md := b.Opf.Metadata
+	refinements := md.refinements()
+	var titles []Title
+	for _, entry := range md.entries("title") {
+		value := strings.TrimSpace(entry.Value)
+		if value == "" {
+			continue
+		}
+		t := Title{
+			ID:     entry.Attrs["id"],
+			Value:  value,
+			Lang:   entry.Attrs["lang"],
+			FileAs: strings.TrimSpace(entry.Attrs["file-as"]),
+			Type:   titleType(entry, refinements),
+		}
+		for _, ref := range refinements[t.ID] {
+			value := strings.TrimSpace(ref.Value)
+			if value == "" {
+				continue
+			}
+			switch ref.Attrs["property"] {
+			case "file-as":
+				t.FileAs = value
+			case "alternate-script":
+				t.AlternateScripts = append(t.AlternateScripts, AlternateScript{Value: value, Lang: ref.Attrs["lang"]})
+			case "display-seq":
+				t.DisplaySeq, _ = strconv.Atoi(value)
+			}
+		}
+		titles = append(titles, t)
+	}
+	if len(titles) > 0 && !slices.ContainsFunc(titles, func(t Title) bool { return t.Type == "main" }) && titles[0].Type == "" {
+		titles[0].Type = "main"
+	}
+	slices.SortStableFunc(titles, func(x, y Title) int { return compareDisplaySeq(x.DisplaySeq, y.DisplaySeq) })
+	return titles
+}
+
+// titleType returns the title-type refinement of the dc:title entry, in lower
+// case.
+func titleType(entry MetaEntry, refinements map[string][]MetaEntry) string {
+	var typ string
+	for _, ref := range refinements[entry.Attrs["id"]] {
+		if value := strings.TrimSpace(ref.Value); ref.Attrs["property"] == "title-type" && value != "" {
+			typ = strings.ToLower(value)
+		}
+	}
+	return typ
+}
+
+// compareDisplaySeq orders declared display-seq values ascending, ahead of
+// entries without one.
+func compareDisplaySeq(x, y int) int {
+	switch {
+	case x == y:
+		return 0
+	case x == 0:
+		return 1
+	case y == 0:
+		return -1
+	}
+	return x - y
+}
+
+// entries returns the entries of tag across namespaces in document order.
+func (md *Metadata) entries(tag string) []MetaEntry {
+	var out []MetaEntry
+	seen := make(map[string]bool)
+	for _, k := range md.order {
+		if k.tag == tag && !seen[k.ns] {
+			seen[k.ns] = true
+			out = append(out, md.Data[k.ns][tag]...)
+		}
+	}
+	for ns, tags := range md.Data {
+		if !seen[ns] {
+			out = append(out, tags[tag]...)
+		}
+	}
+	return out
+}
+
+// refinements groups the EPUB 3 <meta refines="#id"> entries by the id they
+// refine.
+func (md *Metadata) refinements() map[string][]MetaEntry {
+	refs := make(map[string][]MetaEntry)
+	for _, entry := range md.entries("meta") {
+		if id, ok := strings.CutPrefix(strings.TrimSpace(entry.Attrs["refines"]), "#"); ok && id != "" {
+			refs[id] = append(refs[id], entry)
+		}
+	}
+	return refs
+}
+
+// Series 对应丛书信息 / Series is a collection the book belongs to, with the book's position in it.
+type Series struct {
+	Name string `json:"name"`
+	// Index is the position of the book in the collection; fractional
+	// positions such as 2.5 are kept. 0 when not declared.
+	Index float64 `json:"index,omitempty"`
+	// Type is the EPUB 3 collection-type, "series" or "set", or "" when not
+	// declared. Calibre series are reported as "series".
+	Type string `json:"type,omitempty"`
+	ID   string `json:"id,omitempty"` // id of the belongs-to-collection meta
+}
+
+// Collections returns every EPUB 3 belongs-to-collection entry of the
+// package, in document order.
+func (b *Book) Collections() []Series {
+	if b == nil || b.Opf == nil || b.Opf.Metadata == nil {
+		return nil
+	}
+	md := b.Opf.Metadata
+	refinements := md.refinements()
+	var collections []Series
+	for _, entry := range md.entries("meta") {
+		if entry.Attrs["property"] != "belongs-to-collection" || strings.TrimSpace(entry.Attrs["refines"]) != "" {
+			continue
+		}
+		s := Series{Name: collapseSpace(entry.Value), ID: entry.Attrs["id"]}
+		if s.Name == "" {
+			continue
+		}
+		for _, ref := range refinements[s.ID] {
+			switch ref.Attrs["property"] {
+			case "collection-type":
+				s.Type = strings.ToLower(strings.TrimSpace(ref.Value))
+			case "group-position":
+				s.Index = parseSeriesIndex(ref.Value)
+			}
+		}
+		collections = append(collections, s)
+	}
+	return collections
+}
+
+// Series returns the series of the book: the first belongs-to-collection of
+// type series (or without type), falling back to the calibre:series and
+// calibre:series_index meta tags. ErrMetadataUndefined is returned when the
+// book declares no series.
+func (b *Book) Series() (Series, error) {
+	var untyped *Series
+	for _, c := range b.Collections() {
+		if c.Type == "series" {
+			return c, nil
+		}
+		if c.Type == "" && untyped == nil {
+			untyped = &c
+		}
+	}
+	if untyped != nil {
+		return *untyped, nil
+	}
+	if b != nil && b.Opf != nil {
+		if name := collapseSpace(b.Opf.Metadata.metaContent("calibre:series")); name != "" {
+			return Series{
+				Name:  name,
+				Index: parseSeriesIndex(b.Opf.Metadata.metaContent("calibre:series_index")),
+				Type:  "series",
+			}, nil
+		}
+	}
+	return Series{}, fmt.Errorf("%w: series", ErrMetadataUndefined)
+}
+
+func parseSeriesIndex(value string) float64 {
+	index, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(index) || math.IsInf(index, 0) {
+		return 0
+	}
+	return index
+}
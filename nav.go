@@ -0,0 +1,285 @@
+package epub
+
+import (
+	"bytes"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// Landmark 对应 landmarks nav 或 guide 引用 / Landmark is a structural reference such as the cover, the
+// table of contents or the start of the body text.
+type Landmark struct {
+	// Type is the EPUB 3 structural semantic, e.g. cover, toc, titlepage or
+	// bodymatter. EPUB 2 guide types are translated (text becomes
+	// bodymatter, title-page becomes titlepage); unknown types are kept.
+	Type  string `json:"type"`
+	Title string `json:"title,omitempty"`
+	Href  string `json:"href"` // container path, percent-decoded, fragment kept
+}
+
+// PageTarget 对应印刷版页码 / PageTarget maps a print page number to a location in the content.
+type PageTarget struct {
+	Label string `json:"label"`          // page number as printed, e.g. "xii" or "42"
+	Href  string `json:"href"`           // container path, percent-decoded, fragment kept
+	Type  string `json:"type,omitempty"` // NCX pageTarget type: front, normal or special
+}
+
+// NavList 对应其他导航列表 / NavList is a secondary navigation list, such as a list of illustrations
+// (EPUB 3 <nav epub:type="loi">) or an NCX <navList>.
+type NavList struct {
+	Type    string `json:"type,omitempty"` // nav epub:type or navList class
+	Title   string `json:"title,omitempty"`
+	Entries []TOC  `json:"entries,omitempty"`
+}
+
+// navigation holds the navigation structures besides the TOC.
+type navigation struct {
+	landmarks []Landmark
+	pageList  []PageTarget
+	navLists  []NavList
+}
+
+// guideLandmarkTypes 将 EPUB2 guide 类型映射为 EPUB3 语义 / guideLandmarkTypes maps EPUB 2 guide types to
+// their EPUB 3 structural semantics where the names differ.
+var guideLandmarkTypes = map[string]string{
+	"text":             "bodymatter",
+	"start":            "bodymatter",
+	"title-page":       "titlepage",
+	"acknowledgements": "acknowledgments",
+}
+
+// Landmarks returns the landmarks of the book from the EPUB 3 landmarks nav,
+// completed by the OPF guide for types the nav does not list.
+func (b *Book) Landmarks() []Landmark {
+	if b == nil {
+		return nil
+	}
+	return append([]Landmark(nil), b.nav.landmarks...)
+}
+
+// LandmarkByType returns the first landmark of the given type, e.g.
+// "bodymatter" for the start of the text.
+func (b *Book) LandmarkByType(typ string) (Landmark, bool) {
+	if b == nil {
+		return Landmark{}, false
+	}
+	for _, l := range b.nav.landmarks {
+		if strings.EqualFold(l.Type, typ) {
+			return l, true
+		}
+	}
+	return Landmark{}, false
+}
+
+// PageList returns the print page targets from the EPUB 3 page-list nav or,
+// when the book has none, the NCX pageList.
+func (b *Book) PageList() []PageTarget {
+	if b == nil {
+		return nil
+	}
+	return append([]PageTarget(nil), b.nav.pageList...)
+}
+
+// NavLists returns secondary navigation lists such as lists of illustrations
+// or tables.
+func (b *Book) NavLists() []NavList {
+	if b == nil {
+		return nil
+	}
+	return append([]NavList(nil), b.nav.navLists...)
+}
+
+// loadNavigation 读取 landmarks/page-list/guide / loadNavigation collects landmarks, page lists and
+// secondary lists from the navigation document, the NCX and the OPF guide.
+// The structures are optional, so unreadable documents are skipped.
+func loadNavigation(fsys fs.FS, opf *Opf, opfPath string) navigation {
+	var nav navigation
+	if navPath := opf.manifestPathWithProperty(opfPath, "nav"); navPath != "" {
+		if content, err := readXML(fsys, cleanPath(navPath)); err == nil {
+			if root, err := ParseXML(bytes.NewReader(content)); err == nil {
+				nav.readNavDocument(root, pathDir(navPath))
+			}
+		}
+	}
+	if ncxPath := opf.ncxPath(opfPath); ncxPath != "" {
+		if content, err := readXML(fsys, cleanPath(ncxPath)); err == nil {
+			if root, err := ParseXML(bytes.NewReader(content)); err == nil {
+				nav.readNCX(root, pathDir(ncxPath))
+			}
+		}
+	}
+	for _, ref := range opf.guideReferences() {
+		typ := strings.ToLower(strings.TrimSpace(ref.Attrs["type"]))
+		href := strings.TrimSpace(ref.Attrs["href"])
+		if typ == "" || href == "" {
+			continue
+		}
+		if mapped, ok := guideLandmarkTypes[typ]; ok {
+			typ = mapped
+		}
+		if nav.hasLandmark(typ) {
+			continue
+		}
+		nav.landmarks = append(nav.landmarks, Landmark{
+			Type:  typ,
+			Title: strings.TrimSpace(ref.Attrs["title"]),
+			Href:  joinFragment(resolveHref(pathDir(opfPath), href)),
+		})
+	}
+	return nav
+}
+
+func (nav *navigation) hasLandmark(typ string) bool {
+	for _, l := range nav.landmarks {
+		if l.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// readNavDocument 解析 EPUB3 nav 文档中除 toc 外的 nav / readNavDocument reads the landmarks, page-list and other
+// <nav> elements of an EPUB 3 navigation document.
+func (nav *navigation) readNavDocument(root *XmlNode, base string) {
+	for _, n := range root.FindNodes("nav") {
+		types := strings.Fields(epubType(n))
+		ol := n.FindNode("ol")
+		if ol == nil || len(types) == 0 {
+			continue
+		}
+		switch {
+		case slices.Contains(types, "toc"):
+		case slices.Contains(types, "landmarks"):
+			for _, a := range ol.FindNodes("a") {
+				href, _ := a.Attr("href")
+				if href = strings.TrimSpace(href); href == "" {
+					continue
+				}
+				nav.landmarks = append(nav.landmarks, Landmark{
+					Type:  strings.TrimSpace(epubType(a)),
+					Title: strings.TrimSpace(a.NodeText()),
+					Href:  joinFragment(resolveHref(base, href)),
+				})
+			}
+		case slices.Contains(types, "page-list"):
+			for _, a := range ol.FindNodes("a") {
+				href, _ := a.Attr("href")
+				if href = strings.TrimSpace(href); href == "" {
+					continue
+				}
+				nav.pageList = append(nav.pageList, PageTarget{
+					Label: strings.TrimSpace(a.NodeText()),
+					Href:  joinFragment(resolveHref(base, href)),
+				})
+			}
+		default:
+			list := NavList{Type: strings.Join(types, " "), Entries: parseNavList(ol, base)}
+			for _, heading := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
+				if h := n.FindNode(heading); h != nil {
+					list.Title = strings.TrimSpace(h.NodeText())
+					break
+				}
+			}
+			nav.navLists = append(nav.navLists, list)
+		}
+	}
+}
+
+// readNCX 解析 NCX pageList 与 navList / readNCX reads the pageList and navList elements of an NCX.
+// The page list is only used when the navigation document had none.
+func (nav *navigation) readNCX(root *XmlNode, base string) {
+	if pageList := root.FindNode("pageList"); pageList != nil && len(nav.pageList) == 0 {
+		for _, target := range pageList.FindNodes("pageTarget") {
+			label, src := ncxTarget(target)
+			if src == "" {
+				continue
+			}
+			href := joinFragment(resolveHref(base, src))
+			typ, _ := target.Attr("type")
+			nav.pageList = append(nav.pageList, PageTarget{Label: label, Href: href, Type: strings.TrimSpace(typ)})
+		}
+	}
+	if len(nav.navLists) > 0 {
+		return
+	}
+	for _, navList := range root.FindNodes("navList") {
+		class, _ := navList.Attr("class")
+		list := NavList{Type: strings.TrimSpace(class)}
+		for i := range navList.XmlNodes {
+			child := &navList.XmlNodes[i]
+			switch child.XMLName.Local {
+			case "navLabel":
+				list.Title = strings.TrimSpace(child.NodeText())
+			case "navTarget":
+				if label, src := ncxTarget(child); src != "" {
+					list.Entries = append(list.Entries, newTOC(label, base, src))
+				}
+			}
+		}
+		nav.navLists = append(nav.navLists, list)
+	}
+}
+
+// ncxTarget returns the label and content src of an NCX pageTarget or
+// navTarget.
+func ncxTarget(n *XmlNode) (label, src string) {
+	for i := range n.XmlNodes {
+		child := &n.XmlNodes[i]
+		switch child.XMLName.Local {
+		case "navLabel":
+			label = strings.TrimSpace(child.NodeText())
+		case "content":
+			src, _ = child.Attr("src")
+			src = strings.TrimSpace(src)
+		}
+	}
+	return label, src
+}
+
+// epubType returns the epub:type attribute of n.
+func epubType(n *XmlNode) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == "type" && (a.Name.Space == nsOPS || a.Name.Space == "epub") {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// manifestPathWithProperty returns the resolved path of the first manifest
+// item declaring the given property.
+func (opf *Opf) manifestPathWithProperty(opfPath, property string) string {
+	if opf == nil || opf.Manifest == nil {
+		return ""
+	}
+	for _, item := range opf.Manifest.Items {
+		for _, p := range strings.Fields(item.Attrs["properties"]) {
+			if strings.EqualFold(p, property) {
+				return resolveRelative(pathDir(opfPath), item.Attrs["href"])
+			}
+		}
+	}
+	return ""
+}
+
+// ncxPath returns the resolved path of the NCX, referenced by the spine toc
+// attribute or found by media type.
+func (opf *Opf) ncxPath(opfPath string) string {
+	if opf == nil || opf.Manifest == nil {
+		return ""
+	}
+	if opf.Spine != nil {
+		if id := opf.Spine.Attrs["toc"]; id != "" {
+			if item, ok := opf.Manifest.ItemByID(id); ok {
+				return resolveRelative(pathDir(opfPath), item.Attrs["href"])
+			}
+		}
+	}
+	for _, item := range opf.Manifest.Items {
+		if strings.EqualFold(item.Attrs["media-type"], "application/x-dtbncx+xml") {
+			return resolveRelative(pathDir(opfPath), item.Attrs["href"])
+		}
+	}
+	return ""
+}
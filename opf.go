@@ -29,11 +29,24 @@ type Spine struct {
 type MetaEntry struct {
 	Value string            // 文本内容 / Text content
 	Attrs map[string]string // 所有属性 / Attributes (refines, property, scheme, id, etc.)
+
+	spaces map[string]string // 属性命名空间 / attribute namespaces, e.g. opf for opf:role
+	src    *XmlNode          // element the entry was parsed from, nil for added entries
 }
 
 // Metadata 统一存储 namespace -> tag -> entries / Metadata maps namespaces and tags to entries.
 type Metadata struct {
 	Data map[string]map[string][]MetaEntry
+
+	defaultNS string    // namespace of the <metadata> element
+	order     []metaKey // first appearance of each namespace/tag pair
+	dirty     bool      // set by the edit methods, see Book.Save
+}
+
+// metaKey 标识 namespace/tag 组合 / metaKey identifies a namespace and tag pair.
+type metaKey struct {
+	ns  string
+	tag string
 }
 
 type Opf struct {
@@ -86,6 +99,22 @@ func (md *Metadata) First(key string) (string, bool) {
 	return values[0], true
 }
 
+// metaContent returns the content attribute of the first <meta name="name">
+// entry, regardless of its namespace.
+func (md *Metadata) metaContent(name string) string {
+	if md == nil {
+		return ""
+	}
+	for _, ns := range md.Data {
+		for _, entry := range ns["meta"] {
+			if strings.EqualFold(entry.Attrs["name"], name) {
+				return strings.TrimSpace(entry.Attrs["content"])
+			}
+		}
+	}
+	return ""
+}
+
 // ItemByID returns the manifest entry that matches the given ID.
 func (mf *Manifest) ItemByID(id string) (EmptyXmlNode, bool) {
 	if mf == nil {
@@ -231,14 +260,20 @@ func (opf *Opf) ParseMetadata() error {
 	if root == nil {
 		return fmt.Errorf("metadata root is nil")
 	}
-	md := &Metadata{Data: make(map[string]map[string][]MetaEntry)}
-
-	var mdNode *XmlNode
-	if mdNode = root.FindNode("metadata"); mdNode == nil {
+	mdNode := root.FindNode("metadata")
+	if mdNode == nil {
 		return fmt.Errorf("metadata not found")
 	}
+	opf.Metadata = parseMetadataElement(mdNode)
+	return nil
+}
 
+// parseMetadataElement 将 <metadata> 元素转换为 Metadata / parseMetadataElement converts a <metadata> element
+// of a package document or of META-INF/metadata.xml.
+func parseMetadataElement(mdNode *XmlNode) *Metadata {
+	md := &Metadata{Data: make(map[string]map[string][]MetaEntry)}
 	defaultNS := mdNode.XMLName.Space
+	md.defaultNS = defaultNS
 	for i := range mdNode.XmlNodes {
 		child := &mdNode.XmlNodes[i]
 		ns := child.XMLName.Space
@@ -247,22 +282,30 @@ func (opf *Opf) ParseMetadata() error {
 			ns = defaultNS
 		}
 
-		entry := MetaEntry{
-			Value: strings.TrimSpace(child.NodeText()),
-			Attrs: make(map[string]string),
-		}
-		for _, attr := range child.Attrs {
-			entry.Attrs[attr.Name.Local] = attr.Value
-		}
+		entry := metaEntryFor(child)
+		entry.src = child
+		md.add(ns, tag, entry)
+	}
+	md.dirty = false
+	return md
+}
 
-		if _, ok := md.Data[ns]; !ok {
-			md.Data[ns] = make(map[string][]MetaEntry)
+// metaEntryFor converts a metadata element into an entry.
+func metaEntryFor(node *XmlNode) MetaEntry {
+	entry := MetaEntry{
+		Value: strings.TrimSpace(node.NodeText()),
+		Attrs: make(map[string]string),
+	}
+	for _, attr := range node.Attrs {
+		entry.Attrs[attr.Name.Local] = attr.Value
+		if attr.Name.Space != "" {
+			if entry.spaces == nil {
+				entry.spaces = make(map[string]string)
+			}
+			entry.spaces[attr.Name.Local] = attr.Name.Space
 		}
-		md.Data[ns][tag] = append(md.Data[ns][tag], entry)
 	}
-
-	opf.Metadata = md
-	return nil
+	return entry
 }
 
 // ParseManifest 解析 manifest 节点 / ParseManifest parses the manifest section of the OPF document.
@@ -333,6 +376,30 @@ func (opf *Opf) ParseSpine() error {
 	return nil
 }
 
+// guideReferences 返回 OPF2 guide 中的 reference / guideReferences returns the OPF 2 <guide> references.
+func (opf *Opf) guideReferences() []EmptyXmlNode {
+	if opf == nil {
+		return nil
+	}
+	guide := opf.XmlNode.FindNode("guide")
+	if guide == nil {
+		return nil
+	}
+	var refs []EmptyXmlNode
+	for i := range guide.XmlNodes {
+		child := &guide.XmlNodes[i]
+		if child.XMLName.Local != "reference" {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, attr := range child.Attrs {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		refs = append(refs, EmptyXmlNode{Name: "reference", Attrs: attrs})
+	}
+	return refs
+}
+
 func (opf *Opf) FindTOCFile(opfPath string) (tocType string, tocFile string) {
 	baseDir := path.Dir(opfPath)
 	if baseDir == "." {
@@ -385,6 +452,15 @@ func resolveRelative(baseDir, href string) string {
 	return path.Clean(path.Join(baseDir, href))
 }
 
+// pathDir 返回路径所在目录，根目录返回空串 / pathDir returns the directory of name, or "" at the container root.
+func pathDir(name string) string {
+	dir := path.Dir(name)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
 // in 判断有序切片中是否包含目标字符串 / in checks if the sorted slice contains the target string.
 func in(sortedValues []string, target string) bool {
 	index := sort.SearchStrings(sortedValues, target)
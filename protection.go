@@ -0,0 +1,214 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// DRMScheme identifies a DRM system.
+type DRMScheme string
+
+const (
+	DRMNone          DRMScheme = ""
+	DRMAdobeADEPT    DRMScheme = "adobe-adept"
+	DRMReadiumLCP    DRMScheme = "readium-lcp"
+	DRMAppleFairPlay DRMScheme = "apple-fairplay"
+	// DRMUnknown is reported for resources encrypted with an algorithm no
+	// known scheme accounts for, as left behind by some Kindle conversions.
+	DRMUnknown DRMScheme = "unknown"
+)
+
+// Files that DRM systems add to META-INF.
+const (
+	rightsPath  = "META-INF/rights.xml"   // Adobe ADEPT
+	licensePath = "META-INF/license.lcpl" // Readium LCP
+	sinfPath    = "META-INF/sinf.xml"     // Apple FairPlay
+)
+
+// ErrDRMProtected indicates that a book, or a resource of it, is encrypted
+// with a DRM scheme. Errors returned for protected books are *DRMError values
+// wrapping it.
+var ErrDRMProtected = errors.New("book is DRM protected")
+
+// DRMError reports the protection found on a book.
+type DRMError struct {
+	Protection Protection
+}
+
+func (e *DRMError) Error() string {
+	return fmt.Sprintf("%v: %s, %d encrypted resources", ErrDRMProtected, e.Protection.Scheme, len(e.Protection.Encrypted))
+}
+
+func (e *DRMError) Unwrap() error { return ErrDRMProtected }
+
+// Protection 描述书籍的 DRM 保护 / Protection describes the DRM protection of a book.
+type Protection struct {
+	Scheme DRMScheme `json:"scheme,omitempty"`
+	// Encrypted lists the container paths of encrypted resources. Fonts that
+	// are merely obfuscated are not included.
+	Encrypted []string `json:"encrypted,omitempty"`
+	// Files lists the DRM files found in META-INF, e.g. rights.xml.
+	Files []string `json:"files,omitempty"`
+}
+
+// Protected reports whether a DRM scheme was detected.
+func (p Protection) Protected() bool {
+	return p.Scheme != DRMNone
+}
+
+// IsEncrypted reports whether the resource at name is DRM encrypted.
+func (p Protection) IsEncrypted(name string) bool {
+	return slices.Contains(p.Encrypted, cleanPath(name))
+}
+
+// Protection returns the DRM protection detected on the book. Books with DRM
+// can only be read with ReadOptions.AllowDRM or ReadOptions.DRM.
+func (b *Book) Protection() Protection {
+	if b == nil {
+		return Protection{}
+	}
+	p := b.protection
+	p.Encrypted = append([]string(nil), p.Encrypted...)
+	p.Files = append([]string(nil), p.Files...)
+	return p
+}
+
+// detectProtection 检测 DRM / detectProtection inspects encryption.xml and the META-INF files of the
+// known DRM schemes.
+func detectProtection(fsys fs.FS, encryption []EncryptedResource) Protection {
+	var p Protection
+	for _, res := range encryption {
+		if !res.Obfuscated() {
+			p.Encrypted = append(p.Encrypted, res.Path)
+		}
+	}
+	for _, name := range []string{rightsPath, licensePath, sinfPath} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			p.Files = append(p.Files, name)
+		}
+	}
+	if len(p.Encrypted) == 0 && !slices.Contains(p.Files, sinfPath) {
+		// DRM files without encrypted content protect nothing. FairPlay
+		// does not always list its resources in encryption.xml.
+		return p
+	}
+	switch {
+	case slices.Contains(p.Files, licensePath) || keyRetrievedFrom(encryption, "license.lcpl"):
+		p.Scheme = DRMReadiumLCP
+	case slices.Contains(p.Files, rightsPath):
+		// rights.xml is ADEPT's; older Adobe and Barnes & Noble variants
+		// share the format.
+		p.Scheme = DRMAdobeADEPT
+	case slices.Contains(p.Files, sinfPath):
+		p.Scheme = DRMAppleFairPlay
+	default:
+		p.Scheme = DRMUnknown
+	}
+	return p
+}
+
+// keyRetrievedFrom reports whether an encrypted resource retrieves its key
+// from the named file, as LCP does with license.lcpl#/encryption/content_key.
+func keyRetrievedFrom(encryption []EncryptedResource, file string) bool {
+	for _, res := range encryption {
+		if strings.HasPrefix(res.KeyName, file+"#") || res.KeyName == file {
+			return true
+		}
+	}
+	return false
+}
+
+// Unlocker 解锁受 DRM 保护的书籍 / Unlocker unlocks books protected by a DRM scheme, as the lcp
+// package does for Readium LCP given the user's passphrase.
+type Unlocker interface {
+	// Unlock checks that the book stored in fsys can be decrypted, typically
+	// by validating a license found in META-INF, and returns the Decrypter
+	// for its resources.
+	Unlock(fsys fs.FS, protection Protection) (Decrypter, error)
+}
+
+// Decrypter decrypts the resources of an unlocked book.
+type Decrypter interface {
+	// Decrypt returns the plain content of res given its stored bytes,
+	// inflated when the resource was compressed before encryption. Content
+	// longer than maxSize, when positive, is rejected with a *LimitError
+	// before it is fully decompressed.
+	Decrypt(res EncryptedResource, data []byte, maxSize int64) ([]byte, error)
+}
+
+// locked reports whether the resource at name is encrypted and the book has
+// no Decrypter for it.
+func (b *Book) locked(name string) bool {
+	return b.decrypter == nil && b.protection.IsEncrypted(name)
+}
+
+// decryptingFS decrypts the encrypted resources of a book as they are opened.
+type decryptingFS struct {
+	fs.FS
+	b *Book
+}
+
+func (d decryptingFS) Open(name string) (fs.File, error) {
+	res, ok := d.b.encryptedResource(name)
+	if !ok || res.Obfuscated() {
+		return d.FS.Open(name)
+	}
+	f, err := d.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	limit := d.b.limits.MaxEntrySize
+	info, err := f.Stat()
+	if err == nil && limit > 0 {
+		switch {
+		case info.Size() > limit:
+			err = &LimitError{Limit: "entry size", Name: name, Value: info.Size(), Max: limit}
+		case res.OriginalLength > limit:
+			err = &LimitError{Limit: "entry size", Name: name, Value: res.OriginalLength, Max: limit}
+		}
+	}
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	plain, err := d.b.decrypter.Decrypt(res, data, limit)
+	if limitErr := (*LimitError)(nil); errors.As(err, &limitErr) {
+		return nil, limitErr
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "decrypt", Path: name, Err: err}
+	}
+	if limit > 0 && int64(len(plain)) > limit {
+		return nil, &LimitError{Limit: "entry size", Name: name, Value: int64(len(plain)), Max: limit}
+	}
+	return &decryptedFile{Reader: bytes.NewReader(plain), info: decryptedInfo{FileInfo: info, size: int64(len(plain))}}, nil
+}
+
+// decryptedFile is the in-memory plain content of an encrypted resource.
+type decryptedFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *decryptedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *decryptedFile) Close() error { return nil }
+
+// decryptedInfo reports the size of the plain content.
+type decryptedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i decryptedInfo) Size() int64 { return i.size }
@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"path"
+	"slices"
 	"sort"
 	"strings"
 )
@@ -42,27 +44,114 @@ func NewBook() *Book {
 	}
 }
 
+// ReadOptions controls how a book is opened. The zero value reproduces the
+// default behaviour of parsing every chapter up front.
+type ReadOptions struct {
+	// LazyChapters keeps the underlying archive open and defers parsing of
+	// spine documents until they are first requested through ChapterByIndex
+	// or ChapterByID. Metadata, manifest and TOC are still loaded eagerly.
+	// Books opened this way must be released with Close.
+	LazyChapters bool
+	// AllowDRM reads books protected by DRM instead of failing with a
+	// *DRMError. Metadata and unencrypted content are available; encrypted
+	// spine documents are left out of the chapters and opening an encrypted
+	// resource fails with ErrDRMProtected.
+	AllowDRM bool
+	// DRM unlocks protected books so that their encrypted resources are
+	// decrypted transparently, e.g. lcp.Passphrase for Readium LCP. Reading
+	// fails when the unlocker cannot open the book.
+	DRM Unlocker
+	// Limits bounds the sizes, compression ratios and nesting depths
+	// accepted from the archive. The zero value applies the defaults.
+	Limits Limits
+	// Rendition selects the package of multiple-rendition publications. The
+	// zero value reads the default (first) rendition.
+	Rendition RenditionSelector
+}
+
+func firstOptions(opts []ReadOptions) ReadOptions {
+	if len(opts) == 0 {
+		return ReadOptions{}
+	}
+	return opts[0]
+}
+
 // ReadBook parses the EPUB file located at epubPath and populates a Book
 // structure with metadata, table of contents and chapter information.
-func ReadBook(epubPath string) (*Book, error) {
+func ReadBook(epubPath string, opts ...ReadOptions) (book *Book, err error) {
+	options := firstOptions(opts)
 	zr, err := zip.OpenReader(epubPath)
 	if err != nil {
 		return nil, err
 	}
+	if options.LazyChapters {
+		book, err = readBook(&zr.Reader, options)
+		if err != nil {
+			return nil, errors.Join(err, zr.Close())
+		}
+		book.closer = zr
+		return book, nil
+	}
 	defer func() {
 		err = errors.Join(err, zr.Close())
 	}()
+	book, err = readBook(&zr.Reader, options)
+	if book != nil {
+		book.fsys = nil
+		book.epubPath = epubPath
+	}
+	return book, err
+}
 
-	book := NewBook()
+// ReadBookFromReaderAt parses an EPUB archive of the given size that is
+// accessible through r. It is useful when the book is held in memory or in a
+// remote blob store and no file on disk is available.
+func ReadBookFromReaderAt(r io.ReaderAt, size int64, opts ...ReadOptions) (*Book, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return readBook(zr, firstOptions(opts))
+}
+
+// ReadBookFromBytes parses an EPUB archive held entirely in memory.
+func ReadBookFromBytes(data []byte, opts ...ReadOptions) (*Book, error) {
+	return ReadBookFromReaderAt(bytes.NewReader(data), int64(len(data)), opts...)
+}
+
+// ReadBookFS parses an EPUB publication exposed through fsys. The root of fsys
+// must correspond to the root of the container, i.e. it holds
+// META-INF/container.xml. Exploded (unzipped) books can be read with
+// os.DirFS.
+func ReadBookFS(fsys fs.FS, opts ...ReadOptions) (*Book, error) {
+	if fsys == nil {
+		return nil, fmt.Errorf("nil file system")
+	}
+	return readBook(fsys, firstOptions(opts))
+}
 
-	files := collectFiles(zr.File)
+// readBook runs the container -> OPF -> TOC -> chapter pipeline shared by
+// every ReadBook variant.
+func readBook(fsys fs.FS, options ReadOptions) (*Book, error) {
+	book := NewBook()
+	book.fsys = fsys
+	book.lazy = options.LazyChapters
 
-	containerFile, ok := files["META-INF/container.xml"]
-	if !ok {
-		return nil, fmt.Errorf("container.xml not found")
+	book.limits = options.Limits.withDefaults()
+	if zr, ok := fsys.(*zip.Reader); ok {
+		if err := checkArchive(zr, book.limits); err != nil {
+			return nil, err
+		}
 	}
-	containerData, err := getContent(containerFile)
+	// Save copies entries from the raw book.fsys; everything parsed is read
+	// through the limits.
+	fsys = book.contentFS(fsys)
+
+	containerData, err := readXML(fsys, "META-INF/container.xml")
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("container.xml not found")
+		}
 		return nil, fmt.Errorf("read container: %w", err)
 	}
 	container, err := ParseContainer(containerData)
@@ -71,24 +160,47 @@ func ReadBook(epubPath string) (*Book, error) {
 	}
 	book.Container = container
 
-	opfPath, err := container.FindOpfFile()
+	renditions, err := loadRenditions(fsys, container)
 	if err != nil {
 		return nil, err
 	}
-	opfPath = path.Clean(opfPath)
-	opfFile, ok := files[opfPath]
-	if !ok {
-		return nil, fmt.Errorf("opf file not found: %s", opfPath)
+	book.renditions = renditions
+	book.rendition = options.Rendition.selectRendition(renditions)
+	book.publication = loadPublicationMetadata(fsys)
+
+	opf, opfPath := renditions[book.rendition].Package, renditions[book.rendition].Path
+	if opf == nil {
+		// The default rendition is unreadable; fall back to the first one
+		// that parsed.
+		book.rendition = slices.IndexFunc(renditions, func(r Rendition) bool { return r.Package != nil })
+		opf, opfPath = renditions[book.rendition].Package, renditions[book.rendition].Path
 	}
-	opfContent, err := getContent(opfFile)
-	if err != nil {
-		return nil, fmt.Errorf("read opf: %w", err)
+	book.Opf = opf
+	book.opfPath = opfPath
+	// Ignoring an unreadable encryption.xml would return obfuscated fonts
+	// and hide DRM protection.
+	encryptionData, err := readXML(fsys, encryptionPath)
+	if err == nil {
+		book.encryption, err = ParseEncryption(encryptionData)
 	}
-	opf, err := ParseOpf(opfContent)
-	if err != nil {
-		return nil, err
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", encryptionPath, err)
 	}
-	book.Opf = opf
+	book.keys = book.fontKeys()
+	book.protection = detectProtection(fsys, book.encryption)
+	if book.protection.Protected() {
+		switch {
+		case options.DRM != nil:
+			decrypter, err := options.DRM.Unlock(fsys, book.Protection())
+			if err != nil {
+				return nil, fmt.Errorf("unlock %s: %w", book.protection.Scheme, err)
+			}
+			book.decrypter = decrypter
+		case !options.AllowDRM:
+			return nil, &DRMError{Protection: book.Protection()}
+		}
+	}
+	content := book.contentFS(book.fsys)
 
 	opfDir := path.Dir(opfPath)
 	if opfDir == "." {
@@ -101,12 +213,17 @@ func ReadBook(epubPath string) (*Book, error) {
 		if opfDir != "" && strings.HasPrefix(tocRef, opfDir+"/") {
 			tocRef = strings.TrimPrefix(tocRef, opfDir+"/")
 		}
-		entries, parseErr := ParseTOC(tocType, tocRef, opfDir, files)
-		if parseErr != nil {
+		entries, parseErr := ParseTOCFS(tocType, tocRef, opfDir, content)
+		switch {
+		case parseErr == nil:
+			book.TOC = &TOC{Children: entries}
+		case book.locked(path.Join(opfDir, tocRef)):
+			// The navigation document is encrypted; read without TOC.
+		default:
 			return nil, fmt.Errorf("parse toc: %w", parseErr)
 		}
-		book.TOC = &TOC{Children: entries}
 	}
+	book.nav = loadNavigation(content, opf, opfPath)
 
 	chapterIDs := opf.Spine.ExtractChapterIDs()
 	hrefLookup := opf.Manifest.HrefLookup(opfPath)
@@ -115,55 +232,73 @@ func ReadBook(epubPath string) (*Book, error) {
 		if !ok {
 			continue
 		}
-		href = path.Clean(href)
-		chapFile, ok := files[href]
-		if !ok {
+		href = cleanPath(href)
+		if book.locked(href) {
+			continue
+		}
+		if book.lazy {
+			if _, statErr := fs.Stat(fsys, href); statErr != nil {
+				continue
+			}
+			book.spine = append(book.spine, chapterRef{ID: id, Href: href})
+			continue
+		}
+		chapter, parseErr := parseChapterFile(content, id, href, book.TOC)
+		if errors.Is(parseErr, fs.ErrNotExist) {
 			continue
 		}
-		chapter, parseErr := ParseChapter(id, href, chapFile)
 		if parseErr != nil {
 			return nil, fmt.Errorf("parse chapter %s: %w", id, parseErr)
 		}
 		book.Chapters = append(book.Chapters, *chapter)
 	}
+	if book.lazy {
+		book.cache = make([]*Chapter, len(book.spine))
+	}
 
 	return book, nil
 }
 
-// getContent 从 zip.File 读取全部内容 / getContent reads the full content from the zip file entry.
-func getContent(f *zip.File) ([]byte, error) {
-	fileReader, err := f.Open()
+// parseChapterFile opens the chapter document at href inside fsys and parses
+// it, taking the title from toc when it has an entry for the chapter.
+func parseChapterFile(fsys fs.FS, id, href string, toc *TOC) (chapter *Chapter, err error) {
+	f, err := fsys.Open(href)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
-		err = errors.Join(err, fileReader.Close())
+		err = errors.Join(err, f.Close())
 	}()
-	return io.ReadAll(fileReader)
+	chapter, root, err := parseChapterTree(id, href, f, maxDepth(fsys))
+	if limitErr := (*LimitError)(nil); errors.As(err, &limitErr) && limitErr.Name == "" {
+		limitErr.Name = href
+	}
+	if err != nil {
+		return nil, err
+	}
+	chapter.useTOCTitle(toc, root)
+	return chapter, nil
 }
 
-func collectFiles(entries []*zip.File) map[string]*zip.File {
-	files := make(map[string]*zip.File, len(entries))
-	for _, f := range entries {
-		files[f.Name] = f
-	}
-	return files
+// getContent 从归档中读取全部内容 / getContent reads the full content of the named archive entry.
+func getContent(fsys fs.FS, name string) ([]byte, error) {
+	return fs.ReadFile(fsys, name)
+}
+
+// cleanPath 规范化归档内路径 / cleanPath normalises an archive path so it can be used with fs.FS.
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean(name), "/")
 }
 
 func xmlNewDecoder(r io.Reader) *xml.Decoder {
+	r, converted := sniffBOM(r)
 	decoder := xml.NewDecoder(r)
 	decoder.Strict = false
 	decoder.CharsetReader = charsetReader
-	return decoder
-}
-
-func charsetReader(charset string, input io.Reader) (io.Reader, error) {
-	switch strings.ToLower(charset) {
-	case "", "utf-8", "us-ascii":
-		return input, nil
-	default:
-		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	if converted {
+		decoder.CharsetReader = utf8Passthrough
 	}
+	return decoder
 }
 
 func xmlUnmarshal(data []byte, v any) error {
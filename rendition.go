@@ -0,0 +1,226 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// Rendition 对应 container.xml 中的一个 rootfile / Rendition is a rootfile of container.xml, i.e. one
+// package of a publication, with the selection attributes defined by EPUB
+// Multiple-Rendition Publications.
+type Rendition struct {
+	Path       string `json:"path"`                 // container path of the package document
+	Media      string `json:"media,omitempty"`      // rendition:media, a CSS media query
+	Layout     string `json:"layout,omitempty"`     // rendition:layout, reflowable or pre-paginated
+	Language   string `json:"language,omitempty"`   // rendition:language
+	AccessMode string `json:"accessMode,omitempty"` // rendition:accessMode, e.g. "textual visual"
+	Label      string `json:"label,omitempty"`      // rendition:label
+
+	// Package is the parsed package document, nil when it could not be read.
+	Package *Opf `json:"-"`
+}
+
+// RenditionSelector picks the rendition ReadBook loads. Empty fields match
+// any rendition; the first rendition matching every set field is selected
+// and the default (first) rendition is used when none matches. Layout and
+// language fall back to the package metadata when the rootfile does not
+// declare them.
+type RenditionSelector struct {
+	Path string // package document path, exact match
+	// Media matches renditions whose rendition:media query mentions it, e.g.
+	// "color" or "(orientation: landscape)".
+	Media      string
+	Layout     string // reflowable or pre-paginated
+	Language   string // BCP 47 tag; "en" also matches "en-GB"
+	AccessMode string // one of auditory, tactile, textual, visual
+}
+
+// packageMediaTypes lists the rootfile media types that denote a package
+// document.
+var packageMediaTypes = []string{"", "application/epub+zip", "application/oebps-package+xml"}
+
+// Renditions returns the rootfiles that reference package documents, in
+// container order. Packages are not parsed.
+func (c *Container) Renditions() []Rendition {
+	if c == nil {
+		return nil
+	}
+	var renditions []Rendition
+	for _, rf := range c.Rootfiles {
+		fullPath := strings.TrimSpace(rf.Attrs["full-path"])
+		mediaType := strings.ToLower(strings.TrimSpace(rf.Attrs["media-type"]))
+		if fullPath == "" || !slices.Contains(packageMediaTypes, mediaType) {
+			continue
+		}
+		renditions = append(renditions, Rendition{
+			Path:       cleanPath(fullPath),
+			Media:      strings.TrimSpace(rf.Attrs["media"]),
+			Layout:     strings.TrimSpace(rf.Attrs["layout"]),
+			Language:   strings.TrimSpace(rf.Attrs["language"]),
+			AccessMode: strings.TrimSpace(rf.Attrs["accessMode"]),
+			Label:      strings.TrimSpace(rf.Attrs["label"]),
+		})
+	}
+	return renditions
+}
+
+// layout returns the declared layout of the rendition, then the
+// rendition:layout property of its package, defaulting to reflowable.
+func (r Rendition) layout() string {
+	if r.Layout != "" {
+		return r.Layout
+	}
+	if r.Package != nil {
+		if layout := r.Package.Metadata.propertyValue("rendition:layout"); layout != "" {
+			return layout
+		}
+	}
+	return "reflowable"
+}
+
+// languages returns the declared language of the rendition, or the dc:language
+// values of its package.
+func (r Rendition) languages() []string {
+	if r.Language != "" {
+		return []string{r.Language}
+	}
+	if r.Package != nil {
+		return r.Package.Metadata.Get("language")
+	}
+	return nil
+}
+
+// matches reports whether the rendition satisfies every criterion of s.
+func (s RenditionSelector) matches(r Rendition) bool {
+	if s.Path != "" && cleanPath(s.Path) != r.Path {
+		return false
+	}
+	if s.Media != "" && !strings.Contains(strings.ToLower(r.Media), strings.ToLower(strings.TrimSpace(s.Media))) {
+		return false
+	}
+	if s.Layout != "" && !strings.EqualFold(strings.TrimSpace(s.Layout), r.layout()) {
+		return false
+	}
+	if s.Language != "" && !slices.ContainsFunc(r.languages(), func(lang string) bool { return languageMatches(s.Language, lang) }) {
+		return false
+	}
+	if s.AccessMode != "" && !slices.ContainsFunc(strings.Fields(r.AccessMode), func(mode string) bool {
+		return strings.EqualFold(mode, strings.TrimSpace(s.AccessMode))
+	}) {
+		return false
+	}
+	return true
+}
+
+// languageMatches reports whether tag equals want or is a subtag of it.
+func languageMatches(want, tag string) bool {
+	want = strings.ToLower(strings.TrimSpace(want))
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	return tag == want || strings.HasPrefix(tag, want+"-")
+}
+
+// selectRendition returns the index of the rendition chosen by s.
+func (s RenditionSelector) selectRendition(renditions []Rendition) int {
+	if s == (RenditionSelector{}) {
+		return 0
+	}
+	for i, r := range renditions {
+		if r.Package != nil && s.matches(r) {
+			return i
+		}
+	}
+	return 0
+}
+
+// loadRenditions parses the package document of every rendition. Renditions
+// whose package cannot be read keep a nil Package unless they are the only
+// one, in which case the error is returned.
+func loadRenditions(fsys fs.FS, container *Container) ([]Rendition, error) {
+	renditions := container.Renditions()
+	if len(renditions) == 0 {
+		return nil, errors.New("no root file found")
+	}
+	var firstErr error
+	for i := range renditions {
+		content, err := readXML(fsys, renditions[i].Path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				err = fmt.Errorf("opf file not found: %s", renditions[i].Path)
+			} else {
+				err = fmt.Errorf("read opf: %w", err)
+			}
+		} else {
+			renditions[i].Package, err = ParseOpf(content)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if !slices.ContainsFunc(renditions, func(r Rendition) bool { return r.Package != nil }) {
+		return nil, firstErr
+	}
+	return renditions, nil
+}
+
+// loadPublicationMetadata reads META-INF/metadata.xml, which multiple-rendition
+// publications use for metadata shared by all renditions. It is optional.
+func loadPublicationMetadata(fsys fs.FS) *Metadata {
+	content, err := readXML(fsys, "META-INF/metadata.xml")
+	if err != nil {
+		return nil
+	}
+	root, err := ParseXML(bytes.NewReader(content))
+	if err != nil {
+		return nil
+	}
+	mdNode := root.FindNode("metadata")
+	if mdNode == nil {
+		return nil
+	}
+	return parseMetadataElement(mdNode)
+}
+
+// Renditions returns every rendition of the publication, including the one
+// the book was read from.
+func (b *Book) Renditions() []Rendition {
+	if b == nil {
+		return nil
+	}
+	return append([]Rendition(nil), b.renditions...)
+}
+
+// Rendition returns the rendition the book was read from.
+func (b *Book) Rendition() Rendition {
+	if b == nil || b.rendition >= len(b.renditions) {
+		return Rendition{}
+	}
+	return b.renditions[b.rendition]
+}
+
+// PublicationMetadata returns the publication-wide metadata of
+// META-INF/metadata.xml, or nil when the container has none. MetadataValues
+// falls back to it for elements the package does not declare.
+func (b *Book) PublicationMetadata() *Metadata {
+	if b == nil {
+		return nil
+	}
+	return b.publication
+}
+
+// propertyValue returns the value of the first EPUB 3 <meta property> entry
+// that does not refine another element.
+func (md *Metadata) propertyValue(property string) string {
+	if md == nil {
+		return ""
+	}
+	for _, entry := range md.entries("meta") {
+		if entry.Attrs["property"] == property && strings.TrimSpace(entry.Attrs["refines"]) == "" {
+			return strings.TrimSpace(entry.Value)
+		}
+	}
+	return ""
+}
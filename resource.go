@@ -0,0 +1,179 @@
+package epub
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// ErrResourceNotFound indicates that a manifest item or archive entry could not
+// be located.
+var ErrResourceNotFound = errors.New("resource not found")
+
+// Resource describes a publication resource declared in the OPF manifest.
+type Resource struct {
+	ID         string   `json:"id"`
+	Href       string   `json:"href"`       // href as written in the manifest
+	Path       string   `json:"path"`       // href resolved against the OPF location
+	MediaType  string   `json:"mediaType"`  // manifest media-type attribute
+	Size       int64    `json:"size"`       // uncompressed size, -1 when the file is missing
+	Properties []string `json:"properties"` // manifest properties (cover-image, nav, ...)
+}
+
+// HasProperty reports whether the manifest item declares the given property.
+func (r Resource) HasProperty(name string) bool {
+	for _, p := range r.Properties {
+		if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resources lists every manifest item in document order together with its
+// resolved container path and size.
+func (b *Book) Resources() (resources []Resource, err error) {
+	if b == nil || b.Opf == nil || b.Opf.Manifest == nil {
+		return nil, nil
+	}
+	fsys, closer, err := b.acquireFS()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, closer.Close())
+	}()
+
+	resources = make([]Resource, 0, len(b.Opf.Manifest.Items))
+	for _, item := range b.Opf.Manifest.Items {
+		resources = append(resources, b.newResource(fsys, item))
+	}
+	return resources, nil
+}
+
+// ResourceByID returns the manifest item with the given ID.
+func (b *Book) ResourceByID(id string) (Resource, error) {
+	if b == nil || b.Opf == nil {
+		return Resource{}, fmt.Errorf("%w: %s", ErrResourceNotFound, id)
+	}
+	item, ok := b.Opf.Manifest.ItemByID(id)
+	if !ok {
+		return Resource{}, fmt.Errorf("%w: %s", ErrResourceNotFound, id)
+	}
+	fsys, closer, err := b.acquireFS()
+	if err != nil {
+		return Resource{}, err
+	}
+	res := b.newResource(fsys, item)
+	return res, closer.Close()
+}
+
+// OpenResource opens the archive entry at href for reading. The href is a path
+// relative to the container root, as found in Resource.Path, Chapter.Path and
+// Chapter.Images. Fonts obfuscated with the IDPF or Adobe algorithm are
+// deobfuscated while reading, and encrypted resources of books unlocked with
+// ReadOptions.DRM are decrypted. The caller must close the returned reader.
+func (b *Book) OpenResource(href string) (io.ReadCloser, error) {
+	if b == nil {
+		return nil, ErrBookClosed
+	}
+	name := cleanPath(href)
+	if b.locked(name) {
+		return nil, fmt.Errorf("%w: %s", ErrDRMProtected, name)
+	}
+	fsys, closer, err := b.acquireFS()
+	if err != nil {
+		return nil, err
+	}
+	f, err := b.contentFS(fsys).Open(name)
+	if err != nil {
+		closeErr := closer.Close()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.Join(fmt.Errorf("%w: %s", ErrResourceNotFound, name), closeErr)
+		}
+		return nil, errors.Join(err, closeErr)
+	}
+	var rc io.ReadCloser = &resourceReader{File: f, archive: closer}
+	if res, ok := b.encryptedResource(name); ok && res.Obfuscated() {
+		if key, limit := b.keys.key(res.Algorithm); key != nil {
+			rc = &obfuscatedReader{ReadCloser: rc, key: key, limit: limit}
+		}
+	}
+	return rc, nil
+}
+
+func (b *Book) newResource(fsys fs.FS, item EmptyXmlNode) Resource {
+	href := strings.TrimSpace(item.Attrs["href"])
+	res := Resource{
+		ID:         item.Attrs["id"],
+		Href:       href,
+		Path:       cleanPath(resolveRelative(b.opfDir(), href)),
+		MediaType:  strings.TrimSpace(item.Attrs["media-type"]),
+		Size:       -1,
+		Properties: strings.Fields(item.Attrs["properties"]),
+	}
+	if info, err := fs.Stat(fsys, res.Path); err == nil {
+		res.Size = info.Size()
+	}
+	return res
+}
+
+// opfDir returns the directory holding the package document, or "" when it
+// sits at the container root.
+func (b *Book) opfDir() string {
+	return pathDir(b.opfPath)
+}
+
+// acquireFS returns the file system backing the book. Books read eagerly from
+// a path do not keep the archive open, so it is reopened, checked against the
+// read limits again and released through the returned closer.
+func (b *Book) acquireFS() (fs.FS, io.Closer, error) {
+	b.mu.Lock()
+	fsys, epubPath := b.fsys, b.epubPath
+	b.mu.Unlock()
+	if fsys != nil {
+		return fsys, nopCloser{}, nil
+	}
+	if epubPath == "" {
+		return nil, nil, ErrBookClosed
+	}
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The file may have changed since the book was read.
+	if err := checkArchive(&zr.Reader, b.limits); err != nil {
+		return nil, nil, errors.Join(err, zr.Close())
+	}
+	return &zr.Reader, zr, nil
+}
+
+// contentFS returns fsys as documents and resources are read from it: entry
+// sizes are limited and, when the book was unlocked, encrypted resources are
+// decrypted on open. The raw file system is still used by Save, which copies
+// encrypted entries unchanged.
+func (b *Book) contentFS(fsys fs.FS) fs.FS {
+	fsys = limitedFS{FS: fsys, limits: b.limits}
+	if b.decrypter == nil {
+		return fsys
+	}
+	return decryptingFS{FS: fsys, b: b}
+}
+
+// resourceReader closes a reopened archive together with the entry read from
+// it.
+type resourceReader struct {
+	fs.File
+	archive io.Closer
+}
+
+func (r *resourceReader) Close() error {
+	return errors.Join(r.File.Close(), r.archive.Close())
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
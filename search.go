@@ -0,0 +1,340 @@
+package epub
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/ArcadiaLin/go-epub/internal/cjk"
+)
+
+// defaultSnippetRadius is the number of characters shown on each side of a
+// match when SearchOptions.SnippetRadius is zero.
+const defaultSnippetRadius = 40
+
+// SearchOptions tunes Book.Search. The zero value performs a case-sensitive
+// literal search.
+type SearchOptions struct {
+	// IgnoreCase matches regardless of case using Unicode case folding.
+	IgnoreCase bool
+	// WholeWord only reports matches that are not embedded in a longer word.
+	// CJK ideographs and kana count as words of their own, so the option has
+	// no effect at the edge of Chinese or Japanese text.
+	WholeWord bool
+	// Regexp interprets the query as a regular expression (RE2 syntax).
+	Regexp bool
+	// Normalize compares text in Unicode NFKC form, so that full-width and
+	// half-width forms or precomposed and combining characters match.
+	Normalize bool
+	// SnippetRadius is the number of characters of context on each side of
+	// a match. Zero selects a default of 40.
+	SnippetRadius int
+	// MaxHits stops the search after that many hits; zero means no limit.
+	MaxHits int
+}
+
+// SearchHit locates a match inside the book.
+type SearchHit struct {
+	ChapterID    string `json:"chapterId"`
+	ChapterIndex int    `json:"chapterIndex"`
+	Paragraph    int    `json:"paragraph"` // index into Chapter.Paragraphs
+	Offset       int    `json:"offset"`    // character offset of the match in the paragraph
+	Length       int    `json:"length"`    // match length in characters
+	Match        string `json:"match"`     // matched text as written in the paragraph
+	Snippet      string `json:"snippet"`
+	TOC          *TOC   `json:"toc,omitempty"` // last table of contents entry starting at or before the paragraph
+}
+
+// Search looks for query in every chapter paragraph in reading order.
+// Offsets and lengths count Unicode code points of the original paragraph
+// text, independently of normalisation and case folding. Whitespace between
+// two CJK characters, a common artifact of line-wrapped source documents, is
+// ignored when matching.
+func (b *Book) Search(query string, opts SearchOptions) ([]SearchHit, error) {
+	if query == "" {
+		return nil, errors.New("search query is empty")
+	}
+	if b == nil {
+		return nil, nil
+	}
+	m, err := newMatcher(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	radius := opts.SnippetRadius
+	if radius <= 0 {
+		radius = defaultSnippetRadius
+	}
+
+	var hits []SearchHit
+	var toc *TOC
+	for i := 0; i < b.ChapterCount(); i++ {
+		chapter, err := b.ChapterByIndex(i)
+		if err != nil {
+			return hits, err
+		}
+		marks, err := b.tocMarks(chapter)
+		if err != nil {
+			return hits, err
+		}
+		next := 0
+		for p, text := range chapter.Paragraphs {
+			for ; next < len(marks) && marks[next].paragraph <= p; next++ {
+				toc = marks[next].entry
+			}
+			for _, span := range m.find(text) {
+				offset := utf8.RuneCountInString(text[:span[0]])
+				hits = append(hits, SearchHit{
+					ChapterID:    chapter.ID,
+					ChapterIndex: i,
+					Paragraph:    p,
+					Offset:       offset,
+					Length:       utf8.RuneCountInString(text[span[0]:span[1]]),
+					Match:        text[span[0]:span[1]],
+					Snippet:      snippet(text, span[0], span[1], radius),
+					TOC:          toc,
+				})
+				if opts.MaxHits > 0 && len(hits) >= opts.MaxHits {
+					return hits, nil
+				}
+			}
+		}
+		if len(marks) > 0 {
+			toc = marks[len(marks)-1].entry
+		}
+	}
+	return hits, nil
+}
+
+// tocMark is a TOC entry with the paragraph its anchor starts in.
+type tocMark struct {
+	entry     *TOC
+	paragraph int
+}
+
+// tocMarks finds the TOC entries pointing into chapter with TOC.FindByHref,
+// in reading order: the entry for the start of the document, then the entries
+// for its anchors.
+func (b *Book) tocMarks(chapter *Chapter) ([]tocMark, error) {
+	if b.TOC == nil {
+		return nil, nil
+	}
+	first := b.TOC.FindByHref(tocHref(chapter.Path, ""))
+	if first == nil {
+		// No entry points into the document.
+		return nil, nil
+	}
+	var marks []tocMark
+	if _, fragment := first.target(); fragment == "" {
+		marks = append(marks, tocMark{entry: first})
+	}
+	root, err := b.parseDocument(chapter.Path)
+	if err != nil {
+		return nil, err
+	}
+	anchors := newAnchorIndex(root, chapter.Path)
+	for _, id := range anchors.ids() {
+		if entry := b.TOC.FindByHref(tocHref(chapter.Path, id)); entry != nil {
+			loc, _ := anchors.locate(id)
+			marks = append(marks, tocMark{entry: entry, paragraph: loc.paragraph})
+		}
+	}
+	return marks, nil
+}
+
+// tocHref escapes a document path and fragment for TOC.FindByHref.
+func tocHref(docPath, fragment string) string {
+	return (&url.URL{Path: docPath, Fragment: fragment}).String()
+}
+
+// matcher finds query matches on the folded view of a paragraph.
+type matcher struct {
+	opts    SearchOptions
+	literal string
+	re      *regexp.Regexp
+}
+
+func newMatcher(query string, opts SearchOptions) (*matcher, error) {
+	m := &matcher{opts: opts}
+	if opts.Regexp {
+		if opts.Normalize {
+			query = norm.NFKC.String(query)
+		}
+		if opts.IgnoreCase {
+			query = "(?i)" + query
+		}
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, fmt.Errorf("search query: %w", err)
+		}
+		m.re = re
+		return m, nil
+	}
+	m.literal = foldText(query, opts, opts.IgnoreCase).view
+	if m.literal == "" {
+		return nil, errors.New("search query is empty")
+	}
+	return m, nil
+}
+
+// find returns the byte spans of all matches in text.
+func (m *matcher) find(text string) [][2]int {
+	// Regular expressions handle case themselves through (?i).
+	ft := foldText(text, m.opts, m.opts.IgnoreCase && m.re == nil)
+	var spans [][2]int
+	add := func(start, end int) bool {
+		if end <= start {
+			return false
+		}
+		s, e := ft.start[start], ft.end[end-1]
+		if m.opts.WholeWord && !isWholeWord(text, s, e) {
+			return false
+		}
+		spans = append(spans, [2]int{s, e})
+		return true
+	}
+	if m.re != nil {
+		for _, loc := range m.re.FindAllStringIndex(ft.view, -1) {
+			add(loc[0], loc[1])
+		}
+		return spans
+	}
+	for pos := 0; pos < len(ft.view); {
+		i := strings.Index(ft.view[pos:], m.literal)
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		if add(start, start+len(m.literal)) {
+			pos = start + len(m.literal)
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(ft.view[start:])
+		pos = start + size
+	}
+	return spans
+}
+
+// foldedText is a searchable view of a string. start[i] and end[i] give the
+// byte range of the source segment that produced view byte i.
+type foldedText struct {
+	view       string
+	start, end []int
+}
+
+// foldText builds the view used for matching: NFKC normalised when requested,
+// case folded when fold is set, and without whitespace between CJK
+// characters.
+func foldText(s string, opts SearchOptions, fold bool) foldedText {
+	var ft foldedText
+	var sb strings.Builder
+	var caser cases.Caser
+	if fold {
+		caser = cases.Fold()
+	}
+	emit := func(seg string, start, end int) {
+		if fold {
+			seg = caser.String(seg)
+		}
+		sb.WriteString(seg)
+		for range len(seg) {
+			ft.start = append(ft.start, start)
+			ft.end = append(ft.end, end)
+		}
+	}
+
+	var lastRune rune
+	spaceStart, spaceEnd := -1, -1
+	flushSpace := func(next rune) {
+		if spaceStart < 0 {
+			return
+		}
+		if !(cjk.Is(lastRune) && cjk.Is(next)) {
+			emit(s[spaceStart:spaceEnd], spaceStart, spaceEnd)
+		}
+		spaceStart, spaceEnd = -1, -1
+	}
+
+	var it norm.Iter
+	if opts.Normalize {
+		it.InitString(norm.NFKC, s)
+	}
+	for pos := 0; pos < len(s); {
+		var seg string
+		start := pos
+		if opts.Normalize {
+			seg = string(it.Next())
+			pos = it.Pos()
+		} else {
+			_, size := utf8.DecodeRuneInString(s[pos:])
+			pos += size
+			seg = s[start:pos]
+		}
+		first, _ := utf8.DecodeRuneInString(seg)
+		if strings.TrimSpace(seg) == "" {
+			if spaceStart < 0 {
+				spaceStart = start
+			}
+			spaceEnd = pos
+			continue
+		}
+		flushSpace(first)
+		emit(seg, start, pos)
+		lastRune, _ = utf8.DecodeLastRuneInString(seg)
+	}
+	flushSpace(0)
+	ft.view = sb.String()
+	return ft
+}
+
+// isWordRune reports whether r can be part of a space-delimited word.
+func isWordRune(r rune) bool {
+	if cjk.Is(r) {
+		return false
+	}
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// isWholeWord reports whether text[start:end] is not glued to the
+// surrounding word characters.
+func isWholeWord(text string, start, end int) bool {
+	first, _ := utf8.DecodeRuneInString(text[start:end])
+	last, _ := utf8.DecodeLastRuneInString(text[start:end])
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWordRune(before) && isWordRune(first) {
+		return false
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordRune(after) && isWordRune(last) {
+		return false
+	}
+	return true
+}
+
+// snippet returns the match with up to radius characters of context on each
+// side, marking truncation with an ellipsis.
+func snippet(text string, start, end, radius int) string {
+	from := start
+	for n := 0; n < radius && from > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:from])
+		from -= size
+	}
+	to := end
+	for n := 0; n < radius && to < len(text); n++ {
+		_, size := utf8.DecodeRuneInString(text[to:])
+		to += size
+	}
+	out := strings.TrimSpace(text[from:to])
+	if from > 0 {
+		out = "…" + out
+	}
+	if to < len(text) {
+		out += "…"
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package epub
+
+import (
+	"slices"
+	"strings"
+)
+
+// Section 是按目录划分的逻辑章节 / Section is a logical chapter delimited by the table of contents. It
+// may cover only part of a spine document or span several documents.
+type Section struct {
+	Title string `json:"title,omitempty"`
+	Href  string `json:"href,omitempty"` // target of the TOC entry, "" for content before the first entry
+	Depth int    `json:"depth"`          // nesting level of the TOC entry, 0 for top-level entries
+	// Parts lists the chapter ranges the section is made of, in reading order.
+	Parts      []SectionPart `json:"parts,omitempty"`
+	Blocks     []Block       `json:"blocks,omitempty"`
+	Paragraphs []string      `json:"paragraphs,omitempty"`
+}
+
+// SectionPart is a range of top-level blocks of a single chapter.
+type SectionPart struct {
+	ChapterIndex int    `json:"chapterIndex"`
+	ChapterID    string `json:"chapterId"`
+	Start        int    `json:"start"` // first block, index into Chapter.Blocks
+	End          int    `json:"end"`   // one past the last block
+}
+
+// Text joins the section paragraphs separated by blank lines.
+func (s *Section) Text() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(s.Paragraphs, "\n\n")
+}
+
+// sectionCut is the position where a TOC entry starts a section.
+type sectionCut struct {
+	entry   TOC
+	depth   int
+	chapter int
+	block   int
+}
+
+// Sections regroups the reading order according to the TOC. Every entry that
+// points into a chapter starts a new section at its anchor, so a document
+// holding several TOC chapters is split and a TOC chapter spread over several
+// documents is joined. Cuts fall on top-level block boundaries: an anchor in
+// the middle of a paragraph starts the section with that paragraph. Parent
+// entries pointing at the same place as their first child yield empty
+// sections. Content before the first entry forms an untitled section. Books
+// without a usable TOC get one section per chapter.
+func (b *Book) Sections() ([]Section, error) {
+	if b == nil {
+		return nil, nil
+	}
+	chapters := make([]*Chapter, b.ChapterCount())
+	for i := range chapters {
+		chapter, err := b.ChapterByIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		chapters[i] = chapter
+	}
+	cuts, err := b.sectionCuts(chapters)
+	if err != nil {
+		return nil, err
+	}
+	if len(cuts) == 0 {
+		sections := make([]Section, 0, len(chapters))
+		for i, chapter := range chapters {
+			sections = append(sections, newSection(chapters, Section{Title: chapter.Title, Href: chapter.Path}, sectionCut{chapter: i}, sectionCut{chapter: i + 1}))
+		}
+		return sections, nil
+	}
+	var sections []Section
+	if first := cuts[0]; first.chapter > 0 || first.block > 0 {
+		sections = append(sections, newSection(chapters, Section{}, sectionCut{}, first))
+	}
+	for i, cut := range cuts {
+		end := sectionCut{chapter: len(chapters)}
+		if i+1 < len(cuts) {
+			end = cuts[i+1]
+		}
+		head := Section{Title: cut.entry.Title, Href: cut.entry.Href, Depth: cut.depth}
+		sections = append(sections, newSection(chapters, head, cut, end))
+	}
+	return sections, nil
+}
+
+// sectionCuts resolves every TOC entry to its start position, sorted in
+// reading order. Entries outside the reading order are dropped.
+func (b *Book) sectionCuts(chapters []*Chapter) ([]sectionCut, error) {
+	if b.TOC == nil {
+		return nil, nil
+	}
+	var cuts []sectionCut
+	documents := make(map[string]*anchorIndex)
+	var walk func(entries []TOC, depth int) error
+	walk = func(entries []TOC, depth int) error {
+		for _, entry := range entries {
+			if docPath, fragment := entry.target(); docPath != "" {
+				if index := b.chapterIndexByPath(docPath); index >= 0 {
+					cut := sectionCut{entry: entry, depth: depth, chapter: index}
+					if fragment != "" {
+						anchors, ok := documents[docPath]
+						if !ok {
+							root, err := b.parseDocument(chapters[index].Path)
+							if err != nil {
+								return err
+							}
+							anchors = newAnchorIndex(root, chapters[index].Path)
+							documents[docPath] = anchors
+						}
+						if loc, ok := anchors.locate(fragment); ok {
+							cut.block = min(loc.block, len(chapters[index].Blocks))
+						}
+					}
+					cuts = append(cuts, cut)
+				}
+			}
+			if err := walk(entry.Children, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(b.TOC.Children, 0); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(cuts, func(x, y sectionCut) int {
+		if x.chapter != y.chapter {
+			return x.chapter - y.chapter
+		}
+		return x.block - y.block
+	})
+	return cuts, nil
+}
+
+// newSection fills head with the content between start and end.
+func newSection(chapters []*Chapter, head Section, start, end sectionCut) Section {
+	for i := start.chapter; i <= end.chapter && i < len(chapters); i++ {
+		from, to := 0, len(chapters[i].Blocks)
+		if i == start.chapter {
+			from = start.block
+		}
+		if i == end.chapter {
+			to = end.block
+		}
+		if from >= to {
+			continue
+		}
+		head.Parts = append(head.Parts, SectionPart{ChapterIndex: i, ChapterID: chapters[i].ID, Start: from, End: to})
+		head.Blocks = append(head.Blocks, cloneBlocks(chapters[i].Blocks[from:to])...)
+	}
+	head.Paragraphs = extractParagraphs(head.Blocks)
+	return head
+}
@@ -3,15 +3,75 @@ package epub
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"path"
 	"strings"
 )
 
 type TOC struct {
-	Title    string
-	Href     string
-	Children []TOC
+	Title string `json:"title,omitempty"`
+	// Href is the resolved target, i.e. Path with "#Fragment" appended.
+	// External links are kept as written.
+	Href     string `json:"href,omitempty"`
+	Path     string `json:"path,omitempty"`     // container path of the target document, percent-decoded
+	Fragment string `json:"fragment,omitempty"` // anchor id inside the document, percent-decoded
+	Children []TOC  `json:"children,omitempty"`
+}
+
+// newTOC 构建目录项 / newTOC builds an entry for the raw href found in a navigation document
+// located in base.
+func newTOC(title, base, href string) TOC {
+	entry := TOC{Title: title}
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return entry
+	}
+	if isExternalRef(href) {
+		entry.Href = href
+		return entry
+	}
+	entry.Path, entry.Fragment = resolveHref(base, href)
+	entry.Href = joinFragment(entry.Path, entry.Fragment)
+	return entry
+}
+
+// resolveHref 解析 href 并拆分片段 / resolveHref resolves a percent-encoded relative href against
+// base and splits off its fragment. A bare "#id" reference keeps an empty
+// target.
+func resolveHref(base, href string) (target, fragment string) {
+	href, fragment, _ = strings.Cut(strings.TrimSpace(href), "#")
+	if decoded, err := url.PathUnescape(href); err == nil {
+		href = decoded
+	}
+	if decoded, err := url.PathUnescape(fragment); err == nil {
+		fragment = decoded
+	}
+	if href == "" {
+		return "", fragment
+	}
+	return cleanPath(resolveRelative(base, href)), fragment
+}
+
+func joinFragment(target, fragment string) string {
+	if fragment == "" {
+		return target
+	}
+	return target + "#" + fragment
+}
+
+// target returns the document path and fragment of the entry, deriving them
+// from Href for entries built by hand.
+func (t TOC) target() (string, string) {
+	if t.Path != "" || t.Fragment != "" {
+		return t.Path, t.Fragment
+	}
+	if t.Href == "" || isExternalRef(t.Href) {
+		return "", ""
+	}
+	return resolveHref("", t.Href)
 }
 
 // Walk traverses the table-of-contents tree in depth-first order and invokes fn
@@ -32,25 +92,42 @@ func (t TOC) Walk(fn func(TOC) error) error {
 	return nil
 }
 
-// FindByHref returns the first TOC entry whose href matches the provided value.
-// The comparison is performed after cleaning the href to make it resilient to
-// small differences in path formatting.
+// FindByHref returns the first TOC entry whose target matches href, a
+// container path with an optional fragment. Paths are cleaned and
+// percent-decoded before comparison. A path without fragment matches the entry
+// pointing at the start of the document or, failing that, the first entry
+// pointing anywhere into it.
 func (t TOC) FindByHref(href string) *TOC {
-	cleaned := path.Clean(href)
-	var found *TOC
+	target, fragment := resolveHref("", href)
+	if target == "" {
+		return nil
+	}
+	var exact, inDocument *TOC
 	_ = t.Walk(func(entry TOC) error {
-		if found != nil {
+		p, f := entry.target()
+		if p != target {
 			return nil
 		}
-		if entry.Href != "" && path.Clean(entry.Href) == cleaned {
+		if f == fragment {
+			copy := entry
+			exact = &copy
+			return errStopWalk
+		}
+		if fragment == "" && inDocument == nil {
 			copy := entry
-			found = &copy
+			inDocument = &copy
 		}
 		return nil
 	})
-	return found
+	if exact != nil {
+		return exact
+	}
+	return inDocument
 }
 
+// errStopWalk ends a Walk early without reporting an error.
+var errStopWalk = errors.New("stop walk")
+
 // Flatten flattens the table of contents into a slice preserving the natural
 // reading order.
 func (t TOC) Flatten() []TOC {
@@ -62,22 +139,30 @@ func (t TOC) Flatten() []TOC {
 	return entries
 }
 
+// ParseTOC parses the navigation document tocFile, relative to opfDir, found
+// among the archive entries files keyed by name, as ParseTOCFS does.
 func ParseTOC(tocType, tocFile, opfDir string, files map[string]*zip.File) ([]TOC, error) {
-	f, ok := files[path.Join(opfDir, tocFile)]
-	if !ok {
+	return ParseTOCFS(tocType, tocFile, opfDir, limitedFS{FS: zipFiles(files), limits: Limits{}.withDefaults()})
+}
+
+// ParseTOCFS reads the navigation document tocFile, relative to opfDir, from
+// fsys and parses it according to tocType. Entry hrefs are resolved against
+// the directory of the navigation document.
+func ParseTOCFS(tocType, tocFile, opfDir string, fsys fs.FS) ([]TOC, error) {
+	name := cleanPath(path.Join(opfDir, tocFile))
+	content, err := readXML(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
 		return nil, fmt.Errorf("toc file not found: %s", tocFile)
 	}
-
-	content, err := getContent(f)
 	if err != nil {
 		return nil, err
 	}
 
 	switch tocType {
 	case TOCTypeEPUB3:
-		return parseNavXML(content, opfDir)
+		return parseNavXML(content, pathDir(name))
 	case TOCTypeEPUB2:
-		return parseNCX(content, opfDir)
+		return parseNCX(content, pathDir(name))
 	default:
 		return nil, fmt.Errorf("unknown toc type")
 	}
@@ -121,37 +206,38 @@ func parseNavXML(content []byte, basePath string) ([]TOC, error) {
 		return nil, fmt.Errorf("parseNavXML: <ol> not found in toc nav")
 	}
 
-	// 递归解析 <li>
-	var parseList func(*XmlNode) []TOC
-	parseList = func(node *XmlNode) []TOC {
-		var entries []TOC
-		for _, li := range node.XmlNodes {
-			if li.XMLName.Local != "li" {
-				continue
-			}
-			var entry TOC
-			for _, child := range li.XmlNodes {
-				switch child.XMLName.Local {
-				case "a":
+	return parseNavList(ol, basePath), nil
+}
+
+// parseNavList 递归解析 nav 中的 <ol> / parseNavList converts the <li> entries of a navigation <ol>,
+// recursing into nested lists.
+func parseNavList(node *XmlNode, basePath string) []TOC {
+	var entries []TOC
+	for _, li := range node.XmlNodes {
+		if li.XMLName.Local != "li" {
+			continue
+		}
+		var entry TOC
+		for _, child := range li.XmlNodes {
+			switch child.XMLName.Local {
+			case "a":
+				href, _ := child.Attr("href")
+				children := entry.Children
+				entry = newTOC(strings.TrimSpace(child.NodeText()), basePath, href)
+				entry.Children = children
+			case "span":
+				if entry.Title == "" {
 					entry.Title = strings.TrimSpace(child.NodeText())
-					for _, a := range child.Attrs {
-						if a.Name.Local == "href" {
-							entry.Href = resolveRelative(basePath, a.Value)
-							break
-						}
-					}
-				case "ol":
-					entry.Children = parseList(&child)
 				}
-			}
-			if entry.Title != "" {
-				entries = append(entries, entry)
+			case "ol":
+				entry.Children = parseNavList(&child, basePath)
 			}
 		}
-		return entries
+		if entry.Title != "" {
+			entries = append(entries, entry)
+		}
 	}
-
-	return parseList(ol), nil
+	return entries
 }
 
 // parseNCX 解析 EPUB2 toc.ncx
@@ -180,21 +266,15 @@ func parseNCX(content []byte, basePath string) ([]TOC, error) {
 				case "navLabel":
 					label = strings.TrimSpace(c.NodeText())
 				case "content":
-					for _, a := range c.Attrs {
-						if a.Name.Local == "src" {
-							href = resolveRelative(basePath, strings.TrimSpace(a.Value))
-						}
-					}
+					href, _ = c.Attr("src")
 				}
 			}
 
 			children := parseNavPoints(n.XmlNodes)
 			if label != "" {
-				entries = append(entries, TOC{
-					Title:    label,
-					Href:     path.Clean(href),
-					Children: children,
-				})
+				entry := newTOC(label, basePath, href)
+				entry.Children = children
+				entries = append(entries, entry)
 			}
 		}
 		return entries
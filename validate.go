@@ -0,0 +1,463 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Severity grades a validation finding.
+type Severity string
+
+const (
+	SeverityError   Severity = "ERROR"
+	SeverityWarning Severity = "WARNING"
+)
+
+// Validation codes reported in Finding.Code. They are grouped by area in the
+// style of epubcheck: PKG for the container archive, OPF for the package
+// document and NAV for navigation documents.
+const (
+	CodeArchiveUnreadable    = "PKG-001" // the archive cannot be opened as zip
+	CodeMimetypeMissing      = "PKG-002" // no mimetype entry
+	CodeMimetypeNotFirst     = "PKG-003" // mimetype is not the first entry
+	CodeMimetypeCompressed   = "PKG-004" // mimetype entry is compressed
+	CodeMimetypeContent      = "PKG-005" // mimetype content is not application/epub+zip
+	CodeContainerMissing     = "PKG-006" // META-INF/container.xml missing
+	CodeContainerInvalid     = "PKG-007" // container.xml cannot be parsed or has no rootfile
+	CodeRootfileMissing      = "PKG-008" // rootfile full-path does not exist
+	CodeRootfileMediaType    = "PKG-009" // rootfile media-type is not the OPF media type
+	CodeLimitExceeded        = "PKG-010" // the archive or an entry exceeds the default read limits
+	CodeUnsafeEntryName      = "PKG-011" // an entry name is absolute, leaves the container or is not UTF-8
+	CodeEntryUnreadable      = "PKG-012" // an entry cannot be decompressed or read
+	CodeOPFInvalid           = "OPF-001" // package document cannot be parsed
+	CodeMetadataMissing      = "OPF-002" // required Dublin Core element missing
+	CodeUniqueIDMissing      = "OPF-003" // unique-identifier attribute missing
+	CodeUniqueIDDangling     = "OPF-004" // unique-identifier does not match a dc:identifier
+	CodeManifestItemInvalid  = "OPF-005" // manifest item lacks id, href or media-type
+	CodeManifestFileMissing  = "OPF-006" // manifest href not present in the archive
+	CodeSpineIDRefDangling   = "OPF-007" // spine itemref idref not in manifest
+	CodeSpineEmpty           = "OPF-008" // spine has no itemref
+	CodeSpineTOCDangling     = "OPF-009" // spine toc attribute not in manifest
+	CodeDuplicateID          = "OPF-010" // the same id is declared twice
+	CodeDuplicateHref        = "OPF-011" // two manifest items share an href
+	CodeUndeclaredResource   = "OPF-012" // archive entry not listed in the manifest
+	CodePackageVersionAbsent = "OPF-013" // package version attribute missing
+	CodeNavMissing           = "NAV-001" // EPUB 3 package without a nav document
+	CodeNavInvalid           = "NAV-002" // nav document cannot be parsed or has no toc nav
+	CodeNCXMissing           = "NAV-003" // EPUB 2 package without an NCX
+	CodeNCXInvalid           = "NAV-004" // NCX cannot be parsed or has no navMap
+	CodeNavTargetMissing     = "NAV-005" // navigation entry points to a missing file
+)
+
+// Finding is a single validation result.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	File     string   `json:"file,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	if f.File == "" {
+		return fmt.Sprintf("%s(%s): %s", f.Severity, f.Code, f.Message)
+	}
+	return fmt.Sprintf("%s(%s): %s: %s", f.Severity, f.Code, f.File, f.Message)
+}
+
+// Report collects every finding of a validation run.
+type Report struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Valid reports whether the publication produced no errors. Warnings do not
+// make a publication invalid.
+func (r Report) Valid() bool {
+	return len(r.Errors()) == 0
+}
+
+// Errors returns the findings with SeverityError.
+func (r Report) Errors() []Finding { return r.filter(SeverityError) }
+
+// Warnings returns the findings with SeverityWarning.
+func (r Report) Warnings() []Finding { return r.filter(SeverityWarning) }
+
+func (r Report) filter(sev Severity) []Finding {
+	var out []Finding
+	for _, f := range r.Findings {
+		if f.Severity == sev {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (r *Report) add(sev Severity, code, file, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{Severity: sev, Code: code, File: file, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the EPUB archive at epubPath and reports every problem it
+// finds instead of stopping at the first one. Archives exceeding the default
+// Limits are reported without reading their entries.
+func Validate(epubPath string) Report {
+	f, err := os.Open(epubPath)
+	if err != nil {
+		var r Report
+		r.add(SeverityError, CodeArchiveUnreadable, "", "%v", err)
+		return r
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		var r Report
+		r.add(SeverityError, CodeArchiveUnreadable, "", "%v", err)
+		return r
+	}
+	return ValidateReaderAt(f, info.Size())
+}
+
+// ValidateReaderAt is like Validate for an archive accessible through r.
+func ValidateReaderAt(r io.ReaderAt, size int64) Report {
+	var report Report
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		report.add(SeverityError, CodeArchiveUnreadable, "", "%v", err)
+		return report
+	}
+	limits := Limits{}.withDefaults()
+	if err := checkArchive(zr, limits); err != nil {
+		code := CodeLimitExceeded
+		if errors.Is(err, ErrUnsafePath) {
+			code = CodeUnsafeEntryName
+		}
+		report.add(SeverityError, code, "", "%v", err)
+		return report
+	}
+	v := &validator{zr: zr, fsys: limitedFS{FS: zr, limits: limits}, report: &report, files: make(map[string]bool)}
+	for _, f := range zr.File {
+		v.files[f.Name] = true
+	}
+	v.run()
+	return report
+}
+
+// ValidateFS is like Validate for an unpacked publication, such as
+// os.DirFS of an extracted book. The checks on how the mimetype entry is
+// stored in the archive do not apply.
+func ValidateFS(fsys fs.FS) Report {
+	var report Report
+	v := &validator{fsys: limitedFS{FS: fsys, limits: Limits{}.withDefaults()}, report: &report, files: make(map[string]bool)}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			v.files[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		report.add(SeverityError, CodeArchiveUnreadable, "", "%v", err)
+		return report
+	}
+	v.run()
+	return report
+}
+
+type validator struct {
+	zr     *zip.Reader // nil for unpacked publications
+	fsys   fs.FS       // the publication, with the entry size and depth limits
+	report *Report
+	files  map[string]bool
+}
+
+func (v *validator) errorf(code, file, format string, args ...any) {
+	v.report.add(SeverityError, code, file, format, args...)
+}
+
+func (v *validator) warnf(code, file, format string, args ...any) {
+	v.report.add(SeverityWarning, code, file, format, args...)
+}
+
+// read returns the content of the entry name. Entries that exist but cannot
+// be read are reported; missing entries are left to the caller.
+func (v *validator) read(name string) ([]byte, bool) {
+	if _, ok := v.files[name]; !ok {
+		return nil, false
+	}
+	data, err := readXML(v.fsys, name)
+	if err != nil {
+		if errors.Is(err, ErrLimitExceeded) {
+			v.errorf(CodeLimitExceeded, name, "%v", err)
+		} else {
+			v.errorf(CodeEntryUnreadable, name, "%v", err)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+func (v *validator) run() {
+	v.checkMimetype()
+
+	if _, ok := v.files["META-INF/container.xml"]; !ok {
+		v.errorf(CodeContainerMissing, "META-INF/container.xml", "container.xml not found")
+		return
+	}
+	containerData, ok := v.read("META-INF/container.xml")
+	if !ok {
+		return
+	}
+	container, err := ParseContainer(containerData)
+	if err != nil {
+		v.errorf(CodeContainerInvalid, "META-INF/container.xml", "%v", err)
+		return
+	}
+	var opfPath string
+	for _, rf := range container.Rootfiles {
+		fullPath := strings.TrimSpace(rf.Attrs["full-path"])
+		if fullPath == "" {
+			v.errorf(CodeContainerInvalid, "META-INF/container.xml", "rootfile without full-path")
+			continue
+		}
+		if _, ok := v.files[cleanPath(fullPath)]; !ok {
+			v.errorf(CodeRootfileMissing, "META-INF/container.xml", "rootfile %s not found", fullPath)
+		}
+		if mt := strings.TrimSpace(rf.Attrs["media-type"]); !strings.EqualFold(mt, "application/oebps-package+xml") {
+			v.errorf(CodeRootfileMediaType, "META-INF/container.xml", "rootfile %s has media-type %q", fullPath, mt)
+		}
+	}
+	if p, err := container.FindOpfFile(); err == nil {
+		opfPath = cleanPath(p)
+	}
+	if opfPath == "" {
+		v.errorf(CodeContainerInvalid, "META-INF/container.xml", "no usable rootfile")
+		return
+	}
+	opfData, ok := v.read(opfPath)
+	if !ok {
+		return
+	}
+	v.checkPackage(opfPath, opfData)
+}
+
+func (v *validator) checkMimetype() {
+	if !v.files["mimetype"] {
+		v.errorf(CodeMimetypeMissing, "mimetype", "mimetype entry not found")
+		return
+	}
+	if v.zr != nil {
+		if v.zr.File[0].Name != "mimetype" {
+			v.errorf(CodeMimetypeNotFirst, "mimetype", "mimetype must be the first entry in the archive")
+		}
+		for _, f := range v.zr.File {
+			if f.Name == "mimetype" && f.Method != zip.Store {
+				v.errorf(CodeMimetypeCompressed, "mimetype", "mimetype must be stored uncompressed")
+				break
+			}
+		}
+	}
+	if data, ok := v.read("mimetype"); ok && string(data) != epubMimetype {
+		v.errorf(CodeMimetypeContent, "mimetype", "mimetype content is %q, want %q", string(data), epubMimetype)
+	}
+}
+
+func (v *validator) checkPackage(opfPath string, data []byte) {
+	root, err := ParseXML(bytes.NewReader(data))
+	if err != nil {
+		v.errorf(CodeOPFInvalid, opfPath, "%v", err)
+		return
+	}
+	opf := &Opf{XmlNode: root}
+	for _, parse := range []func() error{opf.ParseMetadata, opf.ParseManifest, opf.ParseSpine} {
+		if err := parse(); err != nil {
+			v.errorf(CodeOPFInvalid, opfPath, "%v", err)
+		}
+	}
+	if _, ok := root.Attr("version"); !ok {
+		v.warnf(CodePackageVersionAbsent, opfPath, "package version attribute missing")
+	}
+
+	v.checkDuplicateIDs(opfPath, root)
+	v.checkMetadata(opfPath, opf)
+	declared := v.checkManifest(opfPath, opf)
+	v.checkSpine(opfPath, opf)
+	v.checkNavigation(opfPath, opf)
+
+	var undeclared []string
+	for name := range v.files {
+		if strings.HasSuffix(name, "/") || name == "mimetype" || name == opfPath || strings.HasPrefix(name, "META-INF/") {
+			continue
+		}
+		if !declared[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		v.warnf(CodeUndeclaredResource, name, "file is not declared in the manifest")
+	}
+}
+
+func (v *validator) checkDuplicateIDs(opfPath string, root *XmlNode) {
+	seen := make(map[string]bool)
+	var walk func(*XmlNode)
+	walk = func(n *XmlNode) {
+		for _, attr := range n.Attrs {
+			if attr.Name.Local != "id" || (attr.Name.Space != "" && attr.Name.Space != nsXML) {
+				continue
+			}
+			if seen[attr.Value] {
+				v.errorf(CodeDuplicateID, opfPath, "duplicate id %q", attr.Value)
+			}
+			seen[attr.Value] = true
+		}
+		for i := range n.XmlNodes {
+			walk(&n.XmlNodes[i])
+		}
+	}
+	walk(root)
+}
+
+func (v *validator) checkMetadata(opfPath string, opf *Opf) {
+	if opf.Metadata == nil {
+		return
+	}
+	for _, key := range []string{"title", "identifier", "language"} {
+		if len(opf.Metadata.Get(key)) == 0 {
+			v.errorf(CodeMetadataMissing, opfPath, "required metadata dc:%s missing", key)
+		}
+	}
+	uid := opf.uniqueIdentifier()
+	if uid == "" {
+		v.errorf(CodeUniqueIDMissing, opfPath, "package unique-identifier attribute missing")
+		return
+	}
+	for _, ns := range opf.Metadata.Data {
+		for _, entry := range ns["identifier"] {
+			if entry.Attrs["id"] == uid {
+				return
+			}
+		}
+	}
+	v.errorf(CodeUniqueIDDangling, opfPath, "unique-identifier %q does not reference a dc:identifier", uid)
+}
+
+// checkManifest validates manifest items and returns the set of archive
+// entries they declare.
+func (v *validator) checkManifest(opfPath string, opf *Opf) map[string]bool {
+	declared := make(map[string]bool)
+	if opf.Manifest == nil {
+		return declared
+	}
+	base := pathDir(opfPath)
+	hrefs := make(map[string]string)
+	for _, item := range opf.Manifest.Items {
+		id := strings.TrimSpace(item.Attrs["id"])
+		href := strings.TrimSpace(item.Attrs["href"])
+		if id == "" || href == "" || strings.TrimSpace(item.Attrs["media-type"]) == "" {
+			v.errorf(CodeManifestItemInvalid, opfPath, "manifest item %q must have id, href and media-type", id+href)
+		}
+		if href == "" || isExternalRef(href) {
+			continue
+		}
+		name := archiveName(base, href)
+		declared[name] = true
+		if other, ok := hrefs[name]; ok {
+			v.warnf(CodeDuplicateHref, opfPath, "manifest items %q and %q share href %s", other, id, href)
+		}
+		hrefs[name] = id
+		if _, ok := v.files[name]; !ok {
+			v.errorf(CodeManifestFileMissing, opfPath, "manifest item %q references missing file %s", id, name)
+		}
+	}
+	return declared
+}
+
+func (v *validator) checkSpine(opfPath string, opf *Opf) {
+	if opf.Spine == nil {
+		return
+	}
+	if opf.Spine.Len() == 0 {
+		v.errorf(CodeSpineEmpty, opfPath, "spine has no itemref")
+	}
+	for _, ref := range opf.Spine.Itemrefs {
+		idref := strings.TrimSpace(ref.Attrs["idref"])
+		if _, ok := opf.Manifest.ItemByID(idref); !ok {
+			v.errorf(CodeSpineIDRefDangling, opfPath, "spine itemref %q is not in the manifest", idref)
+		}
+	}
+	if toc, ok := opf.Spine.Attrs["toc"]; ok {
+		if _, found := opf.Manifest.ItemByID(toc); !found {
+			v.errorf(CodeSpineTOCDangling, opfPath, "spine toc %q is not in the manifest", toc)
+		}
+	}
+}
+
+func (v *validator) checkNavigation(opfPath string, opf *Opf) {
+	if opf.Manifest == nil {
+		return
+	}
+	base := pathDir(opfPath)
+	var navHref, ncxHref string
+	for _, item := range opf.Manifest.Items {
+		for _, p := range strings.Fields(item.Attrs["properties"]) {
+			if p == "nav" && navHref == "" {
+				navHref = archiveName(base, item.Attrs["href"])
+			}
+		}
+		if strings.EqualFold(item.Attrs["media-type"], "application/x-dtbncx+xml") && ncxHref == "" {
+			ncxHref = archiveName(base, item.Attrs["href"])
+		}
+	}
+
+	if navHref == "" && opf.isEPUB3() {
+		v.errorf(CodeNavMissing, opfPath, "EPUB 3 package has no manifest item with the nav property")
+	}
+	if ncxHref == "" && !opf.isEPUB3() {
+		v.errorf(CodeNCXMissing, opfPath, "EPUB 2 package has no NCX")
+	}
+	if data, ok := v.read(navHref); ok {
+		entries, err := parseNavXML(data, pathDir(navHref))
+		if err != nil {
+			v.errorf(CodeNavInvalid, navHref, "%v", err)
+		}
+		v.checkTargets(navHref, entries)
+	}
+	if data, ok := v.read(ncxHref); ok {
+		entries, err := parseNCX(data, pathDir(ncxHref))
+		if err != nil {
+			v.errorf(CodeNCXInvalid, ncxHref, "%v", err)
+		}
+		v.checkTargets(ncxHref, entries)
+	}
+}
+
+func (v *validator) checkTargets(file string, entries []TOC) {
+	for _, e := range (TOC{Children: entries}).Flatten() {
+		name := e.Path
+		if name == "" || name == "." {
+			continue
+		}
+		if _, ok := v.files[name]; !ok {
+			v.errorf(CodeNavTargetMissing, file, "entry %q points to missing file %s", e.Title, name)
+		}
+	}
+}
+
+// archiveName resolves href against base and decodes percent escapes so the
+// result can be compared with zip entry names.
+func archiveName(base, href string) string {
+	href = strings.TrimSpace(href)
+	if decoded, err := url.PathUnescape(href); err == nil {
+		href = decoded
+	}
+	return cleanPath(resolveRelative(base, href))
+}
@@ -0,0 +1,603 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	epubMimetype = "application/epub+zip"
+	// writerRoot is the directory holding the package document and every
+	// resource added through a Writer.
+	writerRoot = "EPUB"
+)
+
+// Writer assembles an EPUB 3 publication from Go values. The generated archive
+// stores the mimetype entry first and uncompressed, and contains
+// META-INF/container.xml, the package document, an EPUB 3 navigation document
+// and an EPUB 2 NCX for older reading systems.
+//
+// Resource names passed to the Add methods are paths relative to the package
+// document; the same names can be used to reference resources from chapter
+// content.
+type Writer struct {
+	title       string
+	language    string
+	identifier  string
+	creators    []string
+	metadata    [][2]string // additional Dublin Core key/value pairs
+	modified    time.Time
+	chapters    []writerChapter
+	resources   []writerResource
+	stylesheets []string
+	names       map[string]bool
+	ids         map[string]bool // manifest and package IDs in use
+
+	obfuscateFonts bool
+}
+
+type writerChapter struct {
+	id    string
+	name  string
+	title string
+}
+
+type writerResource struct {
+	id         string
+	name       string
+	mediaType  string
+	properties string
+	data       []byte
+	font       bool
+}
+
+// NewWriter returns a Writer with a random urn:uuid identifier, English as
+// the language and the current time as modification date.
+func NewWriter() *Writer {
+	return &Writer{
+		language:   "en",
+		identifier: "urn:uuid:" + newUUID(),
+		modified:   time.Now().UTC(),
+		names:      make(map[string]bool),
+		ids:        map[string]bool{"pub-id": true, "nav": true, "ncx": true},
+	}
+}
+
+// SetTitle sets the dc:title of the publication.
+func (w *Writer) SetTitle(title string) { w.title = title }
+
+// SetLanguage sets the dc:language of the publication.
+func (w *Writer) SetLanguage(lang string) { w.language = lang }
+
+// SetIdentifier sets the unique identifier of the publication.
+func (w *Writer) SetIdentifier(id string) { w.identifier = id }
+
+// SetModified sets the dcterms:modified timestamp.
+func (w *Writer) SetModified(t time.Time) { w.modified = t.UTC() }
+
+// AddCreator appends a dc:creator.
+func (w *Writer) AddCreator(name string) { w.creators = append(w.creators, name) }
+
+// AddMetadata appends a value for any other Dublin Core element, such as
+// "publisher", "description", "subject" or "date".
+func (w *Writer) AddMetadata(key, value string) error {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if !in(dublinCoreElements, key) {
+		return fmt.Errorf("unknown dublin core element: %s", key)
+	}
+	w.metadata = append(w.metadata, [2]string{key, value})
+	return nil
+}
+
+// AddChapter appends a chapter to the spine and the table of contents and
+// returns the name of its document. Content may be a complete XHTML document
+// or a body fragment; fragments are wrapped in a document that links every
+// stylesheet added so far.
+func (w *Writer) AddChapter(title, content string) (string, error) {
+	name := fmt.Sprintf("chapter-%03d.xhtml", len(w.chapters)+1)
+	return name, w.AddChapterFile(name, title, content)
+}
+
+// AddChapterFile is like AddChapter but stores the chapter under name.
+func (w *Writer) AddChapterFile(name, title, content string) error {
+	if !isXHTMLDocument(content) {
+		content = w.wrapChapter(title, content)
+	}
+	id, err := w.addResource(name, "application/xhtml+xml", "", []byte(content))
+	if err != nil {
+		return err
+	}
+	w.chapters = append(w.chapters, writerChapter{id: id, name: cleanPath(name), title: title})
+	return nil
+}
+
+// AddImage adds an image; its media type is derived from the extension.
+func (w *Writer) AddImage(name string, data []byte) error {
+	_, err := w.addResource(name, "", "", data)
+	return err
+}
+
+// SetCover adds an image and marks it as the cover, both through the EPUB 3
+// cover-image property and the EPUB 2 cover meta.
+func (w *Writer) SetCover(name string, data []byte) error {
+	for i := range w.resources {
+		var props []string
+		for _, p := range strings.Fields(w.resources[i].properties) {
+			if p != "cover-image" {
+				props = append(props, p)
+			}
+		}
+		w.resources[i].properties = strings.Join(props, " ")
+	}
+	_, err := w.addResource(name, "", "cover-image", data)
+	return err
+}
+
+// AddCSS adds a stylesheet. Chapters added afterwards from body fragments
+// link to it automatically.
+func (w *Writer) AddCSS(name string, data []byte) error {
+	if _, err := w.addResource(name, "text/css", "", data); err != nil {
+		return err
+	}
+	w.stylesheets = append(w.stylesheets, cleanPath(name))
+	return nil
+}
+
+// AddFont adds an embedded font.
+func (w *Writer) AddFont(name string, data []byte) error {
+	if _, err := w.addResource(name, "", "", data); err != nil {
+		return err
+	}
+	w.resources[len(w.resources)-1].font = true
+	return nil
+}
+
+// ObfuscateFonts enables IDPF font obfuscation: fonts added through AddFont
+// are stored XORed with the SHA-1 of the identifier and listed in
+// META-INF/encryption.xml.
+func (w *Writer) ObfuscateFonts(enabled bool) { w.obfuscateFonts = enabled }
+
+// AddResource adds an arbitrary resource with an explicit media type and
+// optional manifest properties.
+func (w *Writer) AddResource(name, mediaType string, data []byte, properties ...string) error {
+	_, err := w.addResource(name, mediaType, strings.Join(properties, " "), data)
+	return err
+}
+
+func (w *Writer) addResource(name, mediaType, properties string, data []byte) (string, error) {
+	name = cleanPath(name)
+	if name == "" || name == "." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("invalid resource name: %q", name)
+	}
+	if w.names[name] || name == "nav.xhtml" || name == "toc.ncx" || name == "package.opf" {
+		return "", fmt.Errorf("duplicate resource name: %s", name)
+	}
+	if mediaType == "" {
+		mediaType = mediaTypeByExt(name)
+	}
+	if mediaType == "" {
+		return "", fmt.Errorf("unknown media type for %s", name)
+	}
+	id := w.uniqueID(name)
+	w.names[name] = true
+	w.resources = append(w.resources, writerResource{
+		id:         id,
+		name:       name,
+		mediaType:  mediaType,
+		properties: properties,
+		data:       data,
+	})
+	return id, nil
+}
+
+// uniqueID derives a valid XML ID from the resource name.
+func (w *Writer) uniqueID(name string) string {
+	var sb strings.Builder
+	for _, r := range path.Base(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('-')
+		}
+	}
+	base := sb.String()
+	if base == "" || !(base[0] >= 'a' && base[0] <= 'z' || base[0] >= 'A' && base[0] <= 'Z' || base[0] == '_') {
+		base = "id-" + base
+	}
+	id := base
+	for i := 2; w.ids[id]; i++ {
+		id = base + "-" + strconv.Itoa(i)
+	}
+	w.ids[id] = true
+	return id
+}
+
+func (w *Writer) wrapChapter(title, body string) string {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString("<!DOCTYPE html>\n")
+	fmt.Fprintf(&sb, "<html xmlns=\"%s\" xmlns:epub=\"%s\" xml:lang=\"%s\" lang=\"%s\">\n", nsXHTML, nsOPS,
+		xmlAttrEscaper.Replace(w.language), xmlAttrEscaper.Replace(w.language))
+	sb.WriteString("<head>\n")
+	fmt.Fprintf(&sb, "  <title>%s</title>\n", xmlTextEscaper.Replace(title))
+	for _, css := range w.stylesheets {
+		fmt.Fprintf(&sb, "  <link rel=\"stylesheet\" type=\"text/css\" href=\"%s\"/>\n", xmlAttrEscaper.Replace(css))
+	}
+	sb.WriteString("</head>\n<body>\n")
+	sb.WriteString(body)
+	sb.WriteString("\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// WriteTo writes the publication as an EPUB archive to out.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	if strings.TrimSpace(w.title) == "" {
+		return 0, errors.New("epub writer: title is required")
+	}
+	if len(w.chapters) == 0 {
+		return 0, errors.New("epub writer: at least one chapter is required")
+	}
+
+	cw := &countingWriter{w: out}
+	zw := zip.NewWriter(cw)
+	if err := writeMimetype(zw); err != nil {
+		return cw.n, err
+	}
+
+	type xmlEntry struct {
+		name string
+		node XmlNode
+		head string
+	}
+	files := []xmlEntry{
+		{"META-INF/container.xml", containerNode(writerRoot + "/package.opf"), ""},
+		{writerRoot + "/package.opf", w.packageNode(), ""},
+		{writerRoot + "/nav.xhtml", w.navNode(), "<!DOCTYPE html>\n"},
+		{writerRoot + "/toc.ncx", w.ncxNode(), ""},
+	}
+	if encryption, ok := w.encryptionNode(); ok {
+		files = slices.Insert(files, 1, xmlEntry{encryptionPath, encryption, ""})
+	}
+	for _, f := range files {
+		if err := writeXMLEntry(zw, f.name, &f.node, f.head); err != nil {
+			return cw.n, err
+		}
+	}
+	key := idpfFontKey(w.identifier)
+	for _, res := range w.resources {
+		data := res.data
+		if res.font && w.obfuscateFonts {
+			data = bytes.Clone(data)
+			obfuscate(data, key, 1040)
+		}
+		if err := writeZipEntry(zw, writerRoot+"/"+res.name, data); err != nil {
+			return cw.n, err
+		}
+	}
+	err := zw.Close()
+	return cw.n, err
+}
+
+func (w *Writer) packageNode() XmlNode {
+	pkg := newXmlNode(nsOPF, "package", "",
+		"xmlns", nsOPF, "version", "3.0", "unique-identifier", "pub-id", "xml:lang", w.language)
+
+	md := newXmlNode(nsOPF, "metadata", "", "xmlns:dc", nsDC)
+	md.XmlNodes = append(md.XmlNodes,
+		newXmlNode(nsDC, "identifier", w.identifier, "id", "pub-id"),
+		newXmlNode(nsDC, "title", w.title),
+		newXmlNode(nsDC, "language", w.language),
+	)
+	for _, c := range w.creators {
+		md.XmlNodes = append(md.XmlNodes, newXmlNode(nsDC, "creator", c))
+	}
+	for _, kv := range w.metadata {
+		md.XmlNodes = append(md.XmlNodes, newXmlNode(nsDC, kv[0], kv[1]))
+	}
+	md.XmlNodes = append(md.XmlNodes, newXmlNode(nsOPF, "meta", w.modified.Format("2006-01-02T15:04:05Z"), "property", "dcterms:modified"))
+	for _, res := range w.resources {
+		if strings.Contains(res.properties, "cover-image") {
+			md.XmlNodes = append(md.XmlNodes, newXmlNode(nsOPF, "meta", "", "name", "cover", "content", res.id))
+		}
+	}
+
+	manifest := newXmlNode(nsOPF, "manifest", "")
+	manifest.XmlNodes = append(manifest.XmlNodes,
+		newXmlNode(nsOPF, "item", "", "id", "nav", "href", "nav.xhtml", "media-type", "application/xhtml+xml", "properties", "nav"),
+		newXmlNode(nsOPF, "item", "", "id", "ncx", "href", "toc.ncx", "media-type", "application/x-dtbncx+xml"),
+	)
+	for _, res := range w.resources {
+		attrs := []string{"id", res.id, "href", res.name, "media-type", res.mediaType}
+		if res.properties != "" {
+			attrs = append(attrs, "properties", res.properties)
+		}
+		manifest.XmlNodes = append(manifest.XmlNodes, newXmlNode(nsOPF, "item", "", attrs...))
+	}
+
+	spine := newXmlNode(nsOPF, "spine", "", "toc", "ncx")
+	for _, ch := range w.chapters {
+		spine.XmlNodes = append(spine.XmlNodes, newXmlNode(nsOPF, "itemref", "", "idref", ch.id))
+	}
+
+	pkg.XmlNodes = []XmlNode{md, manifest, spine}
+	return pkg
+}
+
+// encryptionNode lists the obfuscated fonts, reporting false when there are
+// none.
+func (w *Writer) encryptionNode() (XmlNode, bool) {
+	encryption := newXmlNode(nsContainer, "encryption", "", "xmlns", nsContainer, "xmlns:enc", nsXMLEnc)
+	if !w.obfuscateFonts {
+		return encryption, false
+	}
+	for _, res := range w.resources {
+		if !res.font {
+			continue
+		}
+		data := newXmlNode(nsXMLEnc, "EncryptedData", "")
+		cipher := newXmlNode(nsXMLEnc, "CipherData", "")
+		cipher.XmlNodes = []XmlNode{newXmlNode(nsXMLEnc, "CipherReference", "", "URI", writerRoot+"/"+res.name)}
+		data.XmlNodes = []XmlNode{newXmlNode(nsXMLEnc, "EncryptionMethod", "", "Algorithm", AlgorithmIDPFFont), cipher}
+		encryption.XmlNodes = append(encryption.XmlNodes, data)
+	}
+	return encryption, len(encryption.XmlNodes) > 0
+}
+
+func (w *Writer) navNode() XmlNode {
+	html := newXmlNode(nsXHTML, "html", "", "xmlns", nsXHTML, "xmlns:epub", nsOPS, "xml:lang", w.language, "lang", w.language)
+	head := newXmlNode(nsXHTML, "head", "")
+	head.XmlNodes = []XmlNode{newXmlNode(nsXHTML, "title", w.title)}
+	ol := newXmlNode(nsXHTML, "ol", "")
+	for _, ch := range w.chapters {
+		li := newXmlNode(nsXHTML, "li", "")
+		li.XmlNodes = []XmlNode{newXmlNode(nsXHTML, "a", chapterLabel(ch), "href", ch.name)}
+		ol.XmlNodes = append(ol.XmlNodes, li)
+	}
+	nav := newXmlNode(nsXHTML, "nav", "", "epub:type", "toc", "id", "toc")
+	nav.XmlNodes = []XmlNode{newXmlNode(nsXHTML, "h1", w.title), ol}
+	body := newXmlNode(nsXHTML, "body", "")
+	body.XmlNodes = []XmlNode{nav}
+	html.XmlNodes = []XmlNode{head, body}
+	return html
+}
+
+func (w *Writer) ncxNode() XmlNode {
+	ncx := newXmlNode(nsNCX, "ncx", "", "xmlns", nsNCX, "version", "2005-1")
+	head := newXmlNode(nsNCX, "head", "")
+	head.XmlNodes = []XmlNode{
+		newXmlNode(nsNCX, "meta", "", "name", "dtb:uid", "content", w.identifier),
+		newXmlNode(nsNCX, "meta", "", "name", "dtb:depth", "content", "1"),
+		newXmlNode(nsNCX, "meta", "", "name", "dtb:totalPageCount", "content", "0"),
+		newXmlNode(nsNCX, "meta", "", "name", "dtb:maxPageNumber", "content", "0"),
+	}
+	docTitle := newXmlNode(nsNCX, "docTitle", "")
+	docTitle.XmlNodes = []XmlNode{newXmlNode(nsNCX, "text", w.title)}
+	navMap := newXmlNode(nsNCX, "navMap", "")
+	for i, ch := range w.chapters {
+		point := newXmlNode(nsNCX, "navPoint", "", "id", "navpoint-"+strconv.Itoa(i+1), "playOrder", strconv.Itoa(i+1))
+		label := newXmlNode(nsNCX, "navLabel", "")
+		label.XmlNodes = []XmlNode{newXmlNode(nsNCX, "text", chapterLabel(ch))}
+		point.XmlNodes = []XmlNode{label, newXmlNode(nsNCX, "content", "", "src", ch.name)}
+		navMap.XmlNodes = append(navMap.XmlNodes, point)
+	}
+	ncx.XmlNodes = []XmlNode{head, docTitle, navMap}
+	return ncx
+}
+
+func chapterLabel(ch writerChapter) string {
+	if strings.TrimSpace(ch.title) != "" {
+		return ch.title
+	}
+	return ch.name
+}
+
+// Save writes the book as an EPUB archive to w. The mimetype entry is written
+// first and uncompressed. The package document is regenerated from b.Opf only
+// after metadata edits made through the Book methods, keeping the original
+// order of the metadata elements; otherwise it is copied unchanged, like every
+// other entry. Entries of a zip archive are copied byte-for-byte in their
+// original order, except obfuscated fonts whose key changed with an
+// identifier edit: they are obfuscated again with the new key.
+func (b *Book) Save(w io.Writer) (err error) {
+	if b == nil || b.Opf == nil || b.Opf.XmlNode == nil {
+		return errors.New("save: book has no package document")
+	}
+	fsys, closer, err := b.acquireFS()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, closer.Close())
+	}()
+
+	rewrite := b.Opf.Metadata != nil && b.Opf.Metadata.dirty
+	if rewrite {
+		b.Opf.syncMetadata()
+	}
+	keys := b.fontKeys()
+	zw := zip.NewWriter(w)
+	if err := writeMimetype(zw); err != nil {
+		return err
+	}
+	if zr, ok := fsys.(*zip.Reader); ok {
+		for _, f := range zr.File {
+			switch {
+			case f.Name == "mimetype":
+				continue
+			case rewrite && cleanPath(f.Name) == b.opfPath:
+				err = writeXMLEntry(zw, f.Name, b.Opf.XmlNode, "")
+			case b.needsRekey(cleanPath(f.Name), keys):
+				var data []byte
+				if data, err = readZipFile(f); err == nil {
+					if err = b.rekey(cleanPath(f.Name), data, keys); err == nil {
+						err = writeZipEntry(zw, f.Name, data)
+					}
+				}
+			case strings.HasSuffix(f.Name, "/"):
+				// Directory entries carry no data; some archivers still store
+				// an empty deflate stream that zip.Writer refuses to copy.
+				_, err = zw.CreateHeader(&zip.FileHeader{Name: f.Name, Modified: f.Modified})
+			default:
+				err = zw.Copy(f)
+			}
+			if err != nil {
+				return fmt.Errorf("save %s: %w", f.Name, err)
+			}
+		}
+		return zw.Close()
+	}
+
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() || name == "mimetype" {
+			return nil
+		}
+		if rewrite && name == b.opfPath {
+			return writeXMLEntry(zw, name, b.Opf.XmlNode, "")
+		}
+		data, err := getContent(fsys, name)
+		if err != nil {
+			return err
+		}
+		if b.needsRekey(name, keys) {
+			if err := b.rekey(name, data, keys); err != nil {
+				return err
+			}
+		}
+		return writeZipEntry(zw, name, data)
+	})
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	return zw.Close()
+}
+
+func readZipFile(f *zip.File) (data []byte, err error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, rc.Close())
+	}()
+	return io.ReadAll(rc)
+}
+
+func writeMimetype(zw *zip.Writer) error {
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(mw, epubMimetype)
+	return err
+}
+
+func writeZipEntry(zw *zip.Writer, name string, data []byte) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+func writeXMLEntry(zw *zip.Writer, name string, node *XmlNode, head string) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(head)
+	if err := node.Encode(&buf); err != nil {
+		return err
+	}
+	return writeZipEntry(zw, name, buf.Bytes())
+}
+
+func containerNode(opfPath string) XmlNode {
+	container := newXmlNode(nsContainer, "container", "", "xmlns", nsContainer, "version", "1.0")
+	rootfiles := newXmlNode(nsContainer, "rootfiles", "")
+	rootfiles.XmlNodes = []XmlNode{newXmlNode(nsContainer, "rootfile", "", "full-path", opfPath, "media-type", "application/oebps-package+xml")}
+	container.XmlNodes = []XmlNode{rootfiles}
+	return container
+}
+
+// mediaTypeByExt 根据扩展名推断媒体类型 / mediaTypeByExt guesses the media type of an EPUB core media type from its extension.
+func mediaTypeByExt(name string) string {
+	switch ext := strings.ToLower(path.Ext(name)); ext {
+	case ".xhtml", ".html", ".htm":
+		return "application/xhtml+xml"
+	case ".css":
+		return "text/css"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".svg":
+		return "image/svg+xml"
+	case ".webp":
+		return "image/webp"
+	case ".ttf":
+		return "font/ttf"
+	case ".otf":
+		return "font/otf"
+	case ".woff":
+		return "font/woff"
+	case ".woff2":
+		return "font/woff2"
+	case ".js":
+		return "application/javascript"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".mp4", ".m4a":
+		return "audio/mp4"
+	case ".smil":
+		return "application/smil+xml"
+	case ".ncx":
+		return "application/x-dtbncx+xml"
+	default:
+		return mime.TypeByExtension(ext)
+	}
+}
+
+func isXHTMLDocument(content string) bool {
+	head := strings.ToLower(strings.TrimSpace(content))
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	return strings.HasPrefix(head, "<?xml") || strings.Contains(head, "<html")
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -2,6 +2,7 @@ package epub
 
 import (
 	"encoding/xml"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -62,8 +63,10 @@ func (xn *XmlNode) FindNodes(name string) []*XmlNode {
 }
 
 // ParseXML 解析 XML，返回 XmlNode 树 / ParseXML decodes the XML stream into a XmlNode tree.
+// Documents in encodings other than UTF-8 are transcoded according to their
+// byte order mark or XML declaration.
 func ParseXML(r io.Reader) (*XmlNode, error) {
-	decoder := xml.NewDecoder(r)
+	decoder := xmlNewDecoder(r)
 	var root XmlNode
 	if err := decoder.Decode(&root); err != nil {
 		return nil, err
@@ -101,3 +104,164 @@ func (xn *XmlNode) FindNode(name string) *XmlNode {
 	}
 	return nil
 }
+
+// Namespaces used by EPUB documents.
+const (
+	nsOPF       = "http://www.idpf.org/2007/opf"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXHTML     = "http://www.w3.org/1999/xhtml"
+	nsOPS       = "http://www.idpf.org/2007/ops"
+	nsNCX       = "http://www.daisy.org/z3986/2005/ncx/"
+	nsContainer = "urn:oasis:names:tc:opendocument:xmlns:container"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsXMLEnc    = "http://www.w3.org/2001/04/xmlenc#"
+)
+
+// knownPrefixes 常用命名空间前缀 / knownPrefixes maps namespaces to the prefixes conventionally used for them.
+var knownPrefixes = map[string]string{
+	nsOPF:                       "opf",
+	nsDC:                        "dc",
+	nsOPS:                       "epub",
+	nsXML:                       "xml",
+	"http://purl.org/dc/terms/": "dcterms",
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// Encode 将 XmlNode 树序列化为 XML / Encode serialises the tree rooted at xn as indented XML.
+// Namespaces are written using the xmlns declarations carried in the
+// attributes of the tree; undeclared namespaces get their conventional
+// prefix, or become the default namespace when no prefix is known. Mixed
+// content is not preserved: an element's character data is written before its
+// children.
+func (xn *XmlNode) Encode(w io.Writer) error {
+	var sb strings.Builder
+	xn.encode(&sb, nsScope{prefixes: map[string]string{}}, 0)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// nsScope tracks the namespace declarations visible to an element.
+type nsScope struct {
+	defaultNS string
+	prefixes  map[string]string // namespace -> prefix
+}
+
+func (s nsScope) with(attrs []xml.Attr) nsScope {
+	next := nsScope{defaultNS: s.defaultNS, prefixes: s.prefixes}
+	copied := false
+	for _, attr := range attrs {
+		switch {
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			next.defaultNS = attr.Value
+		case attr.Name.Space == "xmlns":
+			if !copied {
+				next.prefixes = make(map[string]string, len(s.prefixes)+1)
+				for k, v := range s.prefixes {
+					next.prefixes[k] = v
+				}
+				copied = true
+			}
+			next.prefixes[attr.Value] = attr.Name.Local
+		}
+	}
+	return next
+}
+
+func (xn *XmlNode) encode(sb *strings.Builder, scope nsScope, depth int) {
+	scope = scope.with(xn.Attrs)
+	var decls []string
+
+	name := xn.XMLName.Local
+	switch space := xn.XMLName.Space; {
+	case space == "" || space == scope.defaultNS:
+	case scope.prefixes[space] != "":
+		name = scope.prefixes[space] + ":" + name
+	case knownPrefixes[space] != "":
+		prefix := knownPrefixes[space]
+		scope = scope.with([]xml.Attr{{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: space}})
+		decls = append(decls, fmt.Sprintf(` xmlns:%s="%s"`, prefix, xmlAttrEscaper.Replace(space)))
+		name = prefix + ":" + name
+	default:
+		scope.defaultNS = space
+		decls = append(decls, fmt.Sprintf(` xmlns="%s"`, xmlAttrEscaper.Replace(space)))
+	}
+
+	var attrs []string
+	for _, attr := range xn.Attrs {
+		var attrName string
+		switch space := attr.Name.Space; {
+		case space == "" && attr.Name.Local == "xmlns":
+			attrName = "xmlns"
+		case space == "xmlns":
+			attrName = "xmlns:" + attr.Name.Local
+		case space == "":
+			attrName = attr.Name.Local
+		case space == nsXML || space == "xml":
+			attrName = "xml:" + attr.Name.Local
+		case scope.prefixes[space] != "":
+			attrName = scope.prefixes[space] + ":" + attr.Name.Local
+		case knownPrefixes[space] != "":
+			prefix := knownPrefixes[space]
+			scope = scope.with([]xml.Attr{{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: space}})
+			decls = append(decls, fmt.Sprintf(` xmlns:%s="%s"`, prefix, xmlAttrEscaper.Replace(space)))
+			attrName = prefix + ":" + attr.Name.Local
+		default:
+			// Undeclared prefixes are kept verbatim by the decoder.
+			attrName = space + ":" + attr.Name.Local
+		}
+		attrs = append(attrs, fmt.Sprintf(` %s="%s"`, attrName, xmlAttrEscaper.Replace(attr.Value)))
+	}
+
+	indent := strings.Repeat("  ", depth)
+	sb.WriteString(indent)
+	sb.WriteString("<" + name)
+	for _, d := range decls {
+		sb.WriteString(d)
+	}
+	for _, a := range attrs {
+		sb.WriteString(a)
+	}
+
+	content := xn.Content
+	if len(xn.XmlNodes) > 0 {
+		content = strings.TrimSpace(content)
+	}
+	if content == "" && len(xn.XmlNodes) == 0 {
+		sb.WriteString("/>\n")
+		return
+	}
+	sb.WriteString(">")
+	if len(xn.XmlNodes) == 0 {
+		sb.WriteString(xmlTextEscaper.Replace(content))
+		sb.WriteString("</" + name + ">\n")
+		return
+	}
+	sb.WriteString("\n")
+	if content != "" {
+		sb.WriteString(indent + "  " + xmlTextEscaper.Replace(content) + "\n")
+	}
+	for i := range xn.XmlNodes {
+		xn.XmlNodes[i].encode(sb, scope, depth+1)
+	}
+	sb.WriteString(indent + "</" + name + ">\n")
+}
+
+// newXmlNode 构造元素节点 / newXmlNode builds an element with attributes given as name/value pairs.
+func newXmlNode(space, local, content string, attrs ...string) XmlNode {
+	node := XmlNode{XMLName: xml.Name{Space: space, Local: local}, Content: content}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		name := xml.Name{Local: attrs[i]}
+		if prefix, local, ok := strings.Cut(attrs[i], ":"); ok {
+			name = xml.Name{Space: prefix, Local: local}
+			if prefix == "xml" {
+				name.Space = nsXML
+			}
+		}
+		node.Attrs = append(node.Attrs, xml.Attr{Name: name, Value: attrs[i+1]})
+	}
+	return node
+}